@@ -0,0 +1,181 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type LogFormat int
+
+const (
+	// Apache's Common Log Format
+	LogCommon LogFormat = iota
+	// Apache's Combined Log Format, which adds the referer and user agent
+	LogCombined
+	// One JSON object per line
+	LogJSON
+)
+
+// Logs a line per request to an io.Writer. Lines are written whole, with a
+// single call to Write.
+//
+//	r.Use(router.NewAccessLog(os.Stdout, router.LogCombined).Middleware)
+type AccessLog struct {
+	out    io.Writer
+	format LogFormat
+	rates  map[string]float64
+	lock   sync.Mutex
+	now    func() time.Time
+}
+
+type accessLogEntry struct {
+	Time      string  `json:"time"`
+	Method    string  `json:"method"`
+	Route     string  `json:"route"`
+	Path      string  `json:"path"`
+	Status    int     `json:"status"`
+	Bytes     int     `json:"bytes"`
+	Duration  float64 `json:"duration_ms"`
+	RemoteIP  string  `json:"remote_ip"`
+	UserAgent string  `json:"user_agent"`
+}
+
+func NewAccessLog(out io.Writer, format LogFormat) *AccessLog {
+	return &AccessLog{out: out, format: format, rates: make(map[string]float64), now: time.Now}
+}
+
+// Logs only the given fraction of the requests to the named route, such as
+// 0.1 for one in ten, or 0 for none. Requests which don't match a route use
+// the "unmatched" name. Sampling must be configured before Middleware is
+// called, later changes don't affect existing middleware.
+func (l *AccessLog) Sample(route string, rate float64) *AccessLog {
+	l.lock.Lock()
+	l.rates[route] = rate
+	l.lock.Unlock()
+	return l
+}
+
+func (l *AccessLog) Middleware(next Handler) Handler {
+	l.lock.Lock()
+	rates := make(map[string]float64, len(l.rates))
+	for route, rate := range l.rates {
+		rates[route] = rate
+	}
+	l.lock.Unlock()
+	return func(out http.ResponseWriter, req *Request) {
+		name := "unmatched"
+		if route := req.Route(); route != nil {
+			name = route.Name
+		}
+		if rate, exists := rates[name]; exists && (rate <= 0 || rand.Float64() >= rate) {
+			next(out, req)
+			return
+		}
+
+		w, out := req.responseRecorder(out)
+		start := l.now()
+		completed := false
+		defer func() {
+			status := w.Status()
+			// a panic which happened before anything was written becomes
+			// a 500 once it's recovered
+			if completed == false && w.status == 0 {
+				status = 500
+			}
+			l.write(req, name, status, w.size, start, l.now().Sub(start))
+		}()
+		next(out, req)
+		completed = true
+	}
+}
+
+func (l *AccessLog) write(req *Request, route string, status int, size int, start time.Time, duration time.Duration) {
+	path := req.RequestURI
+	if len(path) == 0 {
+		path = req.URL.RequestURI()
+	}
+	ip := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	var line []byte
+	if l.format == LogJSON {
+		line, _ = json.Marshal(accessLogEntry{
+			Time:      start.Format(time.RFC3339Nano),
+			Method:    req.Method,
+			Route:     route,
+			Path:      path,
+			Status:    status,
+			Bytes:     size,
+			Duration:  float64(duration) / float64(time.Millisecond),
+			RemoteIP:  ip,
+			UserAgent: req.UserAgent(),
+		})
+	} else {
+		line = make([]byte, 0, 256)
+		line = append(line, orDash(ip)...)
+		line = append(line, " - "...)
+		line = appendEscaped(line, orDash(username(req)))
+		line = append(line, " ["...)
+		line = start.AppendFormat(line, "02/Jan/2006:15:04:05 -0700")
+		line = append(line, "] \""...)
+		line = appendEscaped(line, req.Method+" "+path+" "+req.Proto)
+		line = append(line, "\" "...)
+		line = strconv.AppendInt(line, int64(status), 10)
+		line = append(line, ' ')
+		if size == 0 {
+			line = append(line, '-')
+		} else {
+			line = strconv.AppendInt(line, int64(size), 10)
+		}
+		if l.format == LogCombined {
+			line = append(line, " \""...)
+			line = appendEscaped(line, orDash(req.Referer()))
+			line = append(line, "\" \""...)
+			line = appendEscaped(line, orDash(req.UserAgent()))
+			line = append(line, '"')
+		}
+	}
+	line = append(line, '\n')
+
+	l.lock.Lock()
+	l.out.Write(line)
+	l.lock.Unlock()
+}
+
+func username(req *Request) string {
+	if user, _, ok := req.BasicAuth(); ok {
+		return user
+	}
+	return ""
+}
+
+func orDash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
+
+// escapes quotes, backslashes and control characters as Apache does
+func appendEscaped(line []byte, value string) []byte {
+	const hex = "0123456789abcdef"
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"' || c == '\\':
+			line = append(line, '\\', c)
+		case c < 0x20 || c == 0x7f:
+			line = append(line, '\\', 'x', hex[c>>4], hex[c&0xf])
+		default:
+			line = append(line, c)
+		}
+	}
+	return line
+}
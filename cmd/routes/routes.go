@@ -0,0 +1,62 @@
+// Package routes prints the routing tree of a router. It's meant to be
+// hooked into an application's own command line, so that the routes it
+// registers can be inspected without a debugger:
+//
+//	if len(os.Args) > 1 && os.Args[1] == "routes" {
+//		if err := routes.Run(r, os.Args[2:], os.Stdout); err != nil {
+//			log.Fatal(err)
+//		}
+//		return
+//	}
+package routes
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/karlseguin/router"
+)
+
+var formats = map[string]router.DumpFormat{
+	"text": router.DumpText,
+	"json": router.DumpJSON,
+	"dot":  router.DumpDOT,
+}
+
+// Parses args, -format text|json|dot and -o file, and writes r's routes to
+// the file, or to out if there's none
+func Run(r *router.Router, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("routes", flag.ContinueOnError)
+	flags.SetOutput(out)
+	name := flags.String("format", "text", "text, json or dot")
+	file := flags.String("o", "", "the file to write to, instead of standard output")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	format, err := ParseFormat(*name)
+	if err != nil {
+		return err
+	}
+	if len(*file) == 0 {
+		return r.Dump(out, format)
+	}
+	f, err := os.Create(*file)
+	if err != nil {
+		return err
+	}
+	if err := r.Dump(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func ParseFormat(name string) (router.DumpFormat, error) {
+	format, ok := formats[name]
+	if ok == false {
+		return 0, fmt.Errorf("routes: unknown format %q", name)
+	}
+	return format, nil
+}
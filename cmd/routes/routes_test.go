@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/karlseguin/expect"
+	"github.com/karlseguin/router"
+)
+
+type RoutesTests struct{}
+
+func Test_Routes(t *testing.T) {
+	Expectify(new(RoutesTests), t)
+}
+
+func (_ RoutesTests) WritesTheRequestedFormat() {
+	r := router.New(router.Configure())
+	r.Get("/users/:id", func(out http.ResponseWriter, req *router.Request) {})
+	var out strings.Builder
+	Expect(Run(r, []string{"-format", "dot"}, &out)).To.Equal(nil)
+	Expect(out.String()).To.Contain("digraph routes {")
+
+	out.Reset()
+	Expect(Run(r, nil, &out)).To.Equal(nil)
+	Expect(out.String()).To.Equal("GET\n  users/\n    :id/ => GET:/users/:id\n")
+}
+
+func (_ RoutesTests) RejectsUnknownFormats() {
+	err := Run(router.New(router.Configure()), []string{"-format", "yaml"}, new(strings.Builder))
+	Expect(err.Error()).To.Equal(`routes: unknown format "yaml"`)
+}
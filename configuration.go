@@ -1,8 +1,23 @@
 package router
 
+type PathPolicy int
+
+const (
+	// Paths are cleaned and matched regardless of their trailing slash
+	PathLenient PathPolicy = iota
+	// Only the clean path with the same trailing slash as the route matches
+	PathStrict
+	// Paths which aren't clean or have the wrong trailing slash are
+	// redirected to the route's canonical path
+	PathRedirect
+)
+
 type Configuration struct {
 	paramPoolSize  int
 	paramPoolCount int
+	autoMethods    bool
+	pathPolicy     PathPolicy
+	strictRoutes   bool
 }
 
 func Configure() *Configuration {
@@ -16,3 +31,24 @@ func (c *Configuration) ParamPool(size, count int) *Configuration {
 	c.paramPoolSize, c.paramPoolCount = size, count
 	return c
 }
+
+// HEAD requests without a route execute the GET route and discard the body.
+// OPTIONS requests without a route respond with an Allow header listing the
+// methods routed for the path.
+func (c *Configuration) AutoMethods() *Configuration {
+	c.autoMethods = true
+	return c
+}
+
+// Defaults to PathLenient
+func (c *Configuration) PathPolicy(policy PathPolicy) *Configuration {
+	c.pathPolicy = policy
+	return c
+}
+
+// Registering a route or route name a second time panics rather than being
+// ignored
+func (c *Configuration) StrictRoutes() *Configuration {
+	c.strictRoutes = true
+	return c
+}
@@ -0,0 +1,137 @@
+package router
+
+import (
+	"regexp"
+)
+
+var builtinConstraints = map[string]func(string) bool{
+	"int":   isInt,
+	"uint":  isUint,
+	"uuid":  isUUID,
+	"alpha": isAlpha,
+	"hex":   isHex,
+	"date":  isDate,
+}
+
+// A parameter's constraint, either a named constraint or a regular expression
+type constraint struct {
+	source string
+	match  func(string) bool
+}
+
+func (c *constraint) String() string {
+	return c.source
+}
+
+// Registers a named constraint which can be used in place of a regular
+// expression, as in :id(name). Constraints must be registered before the
+// routes which use them. Host routers also see the constraints of the router
+// they were created from.
+func (r *Router) Constraint(name string, match func(value string) bool) {
+	r.change(func(s *state) {
+		constraints := make(map[string]func(string) bool, len(s.constraints)+1)
+		for existing, m := range s.constraints {
+			constraints[existing] = m
+		}
+		constraints[name] = match
+		s.constraints = constraints
+	})
+}
+
+func (r *Router) namedConstraint(name string) (func(string) bool, bool) {
+	for router := r; router != nil; router = router.parent {
+		if match, exists := router.state().constraints[name]; exists {
+			return match, true
+		}
+	}
+	match, exists := builtinConstraints[name]
+	return match, exists
+}
+
+func (r *Router) newConstraint(source string) (*constraint, error) {
+	if match, exists := r.namedConstraint(source); exists {
+		return &constraint{source, match}, nil
+	}
+	re, err := regexp.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	return &constraint{source, re.MatchString}, nil
+}
+
+func isInt(value string) bool {
+	if len(value) > 1 && value[0] == '-' {
+		value = value[1:]
+	}
+	return isUint(value)
+}
+
+func isUint(value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if c := value[i] | 0x20; c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if isHexByte(value[i]) == false {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexByte(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// 8-4-4-4-12 hexadecimal digits
+func isUUID(value string) bool {
+	if len(value) != 36 {
+		return false
+	}
+	for i := 0; i < 36; i++ {
+		if i == 8 || i == 13 || i == 18 || i == 23 {
+			if value[i] != '-' {
+				return false
+			}
+		} else if isHexByte(value[i]) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// YYYY-MM-DD
+func isDate(value string) bool {
+	if len(value) != 10 || value[4] != '-' || value[7] != '-' {
+		return false
+	}
+	if isUint(value[:4]) == false || isUint(value[5:7]) == false || isUint(value[8:]) == false {
+		return false
+	}
+	month := int(value[5]-'0')*10 + int(value[6]-'0')
+	day := int(value[8]-'0')*10 + int(value[9]-'0')
+	return month >= 1 && month <= 12 && day >= 1 && day <= 31
+}
@@ -0,0 +1,161 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type DumpFormat int
+
+const (
+	// An indented tree per method
+	DumpText DumpFormat = iota
+	// An object with a tree per method
+	DumpJSON
+	// A Graphviz digraph
+	DumpDOT
+)
+
+// A node of the routing tree as written by Dump
+type dumpNode struct {
+	Type     string      `json:"type"`
+	Label    string      `json:"label"`
+	Name     string      `json:"name,omitempty"`
+	Pattern  string      `json:"pattern,omitempty"`
+	Children []*dumpNode `json:"children,omitempty"`
+}
+
+// Writes the routing tree of each method. Children are listed in the order
+// they're tried: static parts, parameters, prefixes and then the glob.
+// Static parts are stored compressed, so a single node can span several
+// segments.
+func (r *Router) Dump(out io.Writer, format DumpFormat) error {
+	t := r.table()
+	patterns := make(map[*Action]string, len(t.registrations))
+	for _, reg := range t.registrations {
+		patterns[reg.action] = reg.pattern
+	}
+	methods := make([]string, 0, len(t.routes))
+	for method := range t.routes {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	trees := make([]*dumpNode, len(methods))
+	for i, method := range methods {
+		trees[i] = dumpTree(t.routes[method], &dumpNode{Type: "method", Label: method}, patterns)
+	}
+
+	switch format {
+	case DumpText:
+		return dumpText(out, trees)
+	case DumpJSON:
+		return dumpJSON(out, trees)
+	case DumpDOT:
+		return dumpDOT(out, trees)
+	}
+	return fmt.Errorf("router: unknown dump format %d", format)
+}
+
+func dumpTree(rp *RoutePart, node *dumpNode, patterns map[*Action]string) *dumpNode {
+	withAction(node, rp.action, patterns)
+	for _, child := range rp.children {
+		node.Children = append(node.Children, dumpTree(child, &dumpNode{Type: "static", Label: child.prefix}, patterns))
+	}
+	for _, param := range rp.params {
+		node.Children = append(node.Children, dumpTree(param.route, &dumpNode{Type: "param", Label: param.segment + "/"}, patterns))
+	}
+	for _, prefix := range rp.prefixes {
+		child := &dumpNode{Type: "prefix", Label: prefix.value + "*" + prefix.variable}
+		node.Children = append(node.Children, withAction(child, prefix.action, patterns))
+	}
+	if rp.globAction != nil {
+		child := &dumpNode{Type: "glob", Label: "*" + rp.globVariable}
+		node.Children = append(node.Children, withAction(child, rp.globAction, patterns))
+	}
+	return node
+}
+
+func withAction(node *dumpNode, action *Action, patterns map[*Action]string) *dumpNode {
+	if action != nil {
+		node.Name, node.Pattern = action.Name, patterns[action]
+	}
+	return node
+}
+
+func dumpText(out io.Writer, trees []*dumpNode) error {
+	var b strings.Builder
+	for _, tree := range trees {
+		b.WriteString(tree.Label)
+		b.WriteByte('\n')
+		if len(tree.Name) != 0 {
+			b.WriteString("  / => " + tree.Name + "\n")
+		}
+		for _, child := range tree.Children {
+			writeTextNode(&b, child, 1)
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func writeTextNode(b *strings.Builder, node *dumpNode, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(node.Label)
+	if node.Type == "prefix" || node.Type == "glob" {
+		b.WriteString(" (" + node.Type + ")")
+	}
+	if len(node.Name) != 0 {
+		b.WriteString(" => " + node.Name)
+	}
+	b.WriteByte('\n')
+	for _, child := range node.Children {
+		writeTextNode(b, child, depth+1)
+	}
+}
+
+func dumpJSON(out io.Writer, trees []*dumpNode) error {
+	byMethod := make(map[string]*dumpNode, len(trees))
+	for _, tree := range trees {
+		byMethod[tree.Label] = tree
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(byMethod)
+}
+
+func dumpDOT(out io.Writer, trees []*dumpNode) error {
+	var b strings.Builder
+	b.WriteString("digraph routes {\n  node [shape=box];\n")
+	id := 0
+	var write func(node *dumpNode, parent int)
+	write = func(node *dumpNode, parent int) {
+		current := id
+		id++
+		label, style := node.Label, ""
+		if node.Type == "prefix" || node.Type == "glob" {
+			label += " (" + node.Type + ")"
+		}
+		if len(node.Name) != 0 {
+			label += "\n" + node.Name
+			style = ", style=bold"
+		}
+		fmt.Fprintf(&b, "  n%d [label=\"%s\"%s];\n", current, dotEscaper.Replace(label), style)
+		if parent != -1 {
+			fmt.Fprintf(&b, "  n%d -> n%d;\n", parent, current)
+		}
+		for _, child := range node.Children {
+			write(child, current)
+		}
+	}
+	for _, tree := range trees {
+		write(tree, -1)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(out, b.String())
+	return err
+}
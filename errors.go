@@ -0,0 +1,38 @@
+package router
+
+import (
+	"errors"
+)
+
+var (
+	ErrBadPattern        = errors.New("bad pattern")
+	ErrDuplicateRoute    = errors.New("duplicate route")
+	ErrDuplicateName     = errors.New("duplicate name")
+	ErrDuplicateVariable = errors.New("duplicate variable")
+)
+
+// Returned when a route can't be registered. Err is one of the above
+// errors, Reason describes the specific problem.
+type RouteError struct {
+	Method string
+	Path   string
+	Err    error
+	Reason string
+}
+
+func (e *RouteError) Error() string {
+	message := "router: " + e.Method + " " + e.Path + ": " + e.Err.Error()
+	if len(e.Reason) != 0 {
+		message += ", " + e.Reason
+	}
+	return message
+}
+
+func (e *RouteError) Unwrap() error {
+	return e.Err
+}
+
+func isDuplicate(err error) bool {
+	re, ok := err.(*RouteError)
+	return ok && (re.Err == ErrDuplicateRoute || re.Err == ErrDuplicateName)
+}
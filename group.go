@@ -0,0 +1,159 @@
+package router
+
+import (
+	"strings"
+)
+
+// Where a group registers its routes, either a Router or a Builder
+type registrar interface {
+	Define(def Definition)
+	TryDefine(def Definition) error
+	addNotFound(prefix string, handler Handler)
+}
+
+// A set of routes sharing a path prefix, middleware and not found handler
+type Group struct {
+	router     registrar
+	prefix     string
+	names      string
+	middleware []Middleware
+}
+
+func (r *Router) Group(prefix string) *Group {
+	return &Group{router: r, prefix: cleanPrefix(prefix)}
+}
+
+// Creates a nested group which inherits this group's prefix, name prefix
+// and middleware
+func (g *Group) Group(prefix string) *Group {
+	middleware := make([]Middleware, len(g.middleware))
+	copy(middleware, g.middleware)
+	return &Group{
+		router:     g.router,
+		prefix:     g.prefix + cleanPrefix(prefix),
+		names:      g.names,
+		middleware: middleware,
+	}
+}
+
+// Prepended to the name of routes registered with AddNamed and AllNamed
+func (g *Group) NamePrefix(prefix string) *Group {
+	g.names += prefix
+	return g
+}
+
+// Middleware is applied when a route is registered, so it must be added
+// before the group's routes. The first middleware is the outermost.
+func (g *Group) Use(middleware ...Middleware) *Group {
+	g.middleware = append(g.middleware, middleware...)
+	return g
+}
+
+// Called for requests under the group's prefix which don't match a route
+func (g *Group) NotFound(handler Handler) {
+	g.router.addNotFound(g.prefix, g.wrap(handler))
+}
+
+func (g *Group) Add(method, path string, handler Handler, middleware ...Middleware) {
+	g.router.Define(g.definition(Definition{Method: method, Path: path, Handler: handler, Middleware: middleware}))
+}
+
+func (g *Group) AddNamed(name, method, path string, handler Handler, middleware ...Middleware) {
+	g.router.Define(g.definition(Definition{Name: name, Method: method, Path: path, Handler: handler, Middleware: middleware}))
+}
+
+func (g *Group) TryAdd(method, path string, handler Handler, middleware ...Middleware) error {
+	return g.router.TryDefine(g.definition(Definition{Method: method, Path: path, Handler: handler, Middleware: middleware}))
+}
+
+func (g *Group) TryAddNamed(name, method, path string, handler Handler, middleware ...Middleware) error {
+	return g.router.TryDefine(g.definition(Definition{Name: name, Method: method, Path: path, Handler: handler, Middleware: middleware}))
+}
+
+// The path and name are relative to the group
+func (g *Group) Define(def Definition) {
+	g.router.Define(g.definition(def))
+}
+
+func (g *Group) TryDefine(def Definition) error {
+	return g.router.TryDefine(g.definition(def))
+}
+
+func (g *Group) All(path string, handler Handler, middleware ...Middleware) {
+	for _, method := range AllMethods {
+		g.Add(method, path, handler, middleware...)
+	}
+}
+
+func (g *Group) AllNamed(name, path string, handler Handler, middleware ...Middleware) {
+	for _, method := range AllMethods {
+		g.AddNamed(name, method, path, handler, middleware...)
+	}
+}
+
+func (g *Group) Get(path string, handler Handler, middleware ...Middleware) {
+	g.Add("GET", path, handler, middleware...)
+}
+
+func (g *Group) Post(path string, handler Handler, middleware ...Middleware) {
+	g.Add("POST", path, handler, middleware...)
+}
+
+func (g *Group) Put(path string, handler Handler, middleware ...Middleware) {
+	g.Add("PUT", path, handler, middleware...)
+}
+
+func (g *Group) Delete(path string, handler Handler, middleware ...Middleware) {
+	g.Add("DELETE", path, handler, middleware...)
+}
+
+func (g *Group) Purge(path string, handler Handler, middleware ...Middleware) {
+	g.Add("PURGE", path, handler, middleware...)
+}
+
+func (g *Group) Patch(path string, handler Handler, middleware ...Middleware) {
+	g.Add("PATCH", path, handler, middleware...)
+}
+
+func (g *Group) Options(path string, handler Handler, middleware ...Middleware) {
+	g.Add("OPTIONS", path, handler, middleware...)
+}
+
+func (g *Group) Head(path string, handler Handler, middleware ...Middleware) {
+	g.Add("HEAD", path, handler, middleware...)
+}
+
+func (g *Group) path(path string) string {
+	if path == "" || path == "/" {
+		if g.prefix == "" {
+			return "/"
+		}
+		return g.prefix
+	}
+	return g.prefix + "/" + strings.TrimLeft(path, "/")
+}
+
+// prefixes the definition's path and name, and wraps its handler with the
+// group's middleware
+func (g *Group) definition(def Definition) Definition {
+	def.Path = g.path(def.Path)
+	if len(def.Name) != 0 {
+		def.Name = g.names + def.Name
+	}
+	def.Handler = g.wrap(chain(def.Handler, def.Middleware))
+	def.Middleware = nil
+	return def
+}
+
+func (g *Group) wrap(handler Handler) Handler {
+	return chain(handler, g.middleware)
+}
+
+// normalizes a prefix to have a leading slash and no trailing slash
+func cleanPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if len(prefix) == 0 {
+		return ""
+	}
+	return "/" + prefix
+}
@@ -0,0 +1,107 @@
+package router
+
+import (
+	"sort"
+	"strings"
+
+	"gopkg.in/karlseguin/params.v2"
+)
+
+type host struct {
+	pattern   string
+	labels    []string
+	variables bool
+	router    *Router
+}
+
+// Returns a router for requests whose Host matches pattern. Labels of the
+// pattern which start with a ':' are captured as parameters, so that
+// ":tenant.example.com" makes the first label available through
+// req.Param("tenant"), unless the path has a parameter of the same name, which
+// takes precedence. Requests for hosts which don't match any pattern are
+// routed by r. The returned router runs after r's middleware, and shares r's
+// configuration, named constraints and, unless given its own, panic handler.
+func (r *Router) Host(pattern string) *Router {
+	pattern = strings.ToLower(stripPort(pattern))
+	var router *Router
+	r.change(func(s *state) {
+		for _, h := range s.hosts {
+			if h.pattern == pattern {
+				router = h.router
+				return
+			}
+		}
+		router = newRouter(r.config, r.ParamPool, r.valuePool)
+		router.parent = r
+		h := &host{
+			pattern: pattern,
+			labels:  strings.Split(pattern, "."),
+			router:  router,
+		}
+		for _, label := range h.labels {
+			if len(label) > 1 && label[0] == ':' {
+				h.variables = true
+			}
+		}
+		hosts := append(s.hosts[:len(s.hosts):len(s.hosts)], h)
+		// literal hosts take precedence over those with parameters
+		sort.SliceStable(hosts, func(i, j int) bool {
+			return hosts[i].variables == false && hosts[j].variables
+		})
+		s.hosts = hosts
+	})
+	return router
+}
+
+func (r *Router) routerFor(s *state, hostname string) (*Router, *host) {
+	for _, h := range s.hosts {
+		if h.match(hostname, nil) {
+			return h.router, h
+		}
+	}
+	return r, nil
+}
+
+// when p isn't nil, captured labels are added to it, without replacing the
+// path's parameters
+func (h *host) match(hostname string, p *params.Params) bool {
+	hostname = stripPort(hostname)
+	for i, label := range h.labels {
+		var value string
+		if i == len(h.labels)-1 {
+			value = hostname
+		} else {
+			index := strings.IndexByte(hostname, '.')
+			if index == -1 {
+				return false
+			}
+			value, hostname = hostname[:index], hostname[index+1:]
+		}
+		if len(label) > 1 && label[0] == ':' {
+			if len(value) == 0 {
+				return false
+			}
+			if p != nil {
+				if _, exists := p.Get(label[1:]); exists == false {
+					p.Set(label[1:], value)
+				}
+			}
+		} else if strings.EqualFold(label, value) == false {
+			return false
+		}
+	}
+	return true
+}
+
+func stripPort(hostname string) string {
+	i := strings.LastIndexByte(hostname, ':')
+	if i == -1 || i == len(hostname)-1 {
+		return hostname
+	}
+	for _, c := range hostname[i+1:] {
+		if c < '0' || c > '9' {
+			return hostname
+		}
+	}
+	return hostname[:i]
+}
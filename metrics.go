@@ -0,0 +1,172 @@
+package router
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Prometheus' default buckets, in seconds
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Records the requests, status classes, latencies and in-flight requests of
+// each route, keyed by the route's name and method. Requests which don't
+// match a route are recorded under the "unmatched" route, with methods other
+// than AllMethods recorded as "OTHER".
+//
+//	metrics := router.NewMetrics()
+//	r.Use(metrics.Middleware)
+//	r.Get("/metrics", metrics.Handler)
+type Metrics struct {
+	buckets []float64
+	routes  sync.Map
+}
+
+type metricsKey struct {
+	route  string
+	method string
+}
+
+type routeMetrics struct {
+	// in nanoseconds
+	sum      uint64
+	inFlight int64
+	// 1xx to 5xx
+	codes [5]uint64
+	// the last one counts durations above every bucket
+	buckets []uint64
+}
+
+// Creates metrics whose latency histograms have the given buckets, in
+// seconds. DefaultBuckets are used when none are given.
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+	return &Metrics{buckets: sorted}
+}
+
+func (m *Metrics) Middleware(next Handler) Handler {
+	return func(out http.ResponseWriter, req *Request) {
+		// the method of an unmatched request is whatever the client sent, so
+		// it's limited to the known methods to keep the number of series bounded
+		key := metricsKey{route: "unmatched", method: "OTHER"}
+		if route := req.Route(); route != nil {
+			key = metricsKey{route: route.Name, method: route.Method}
+		} else if contains(AllMethods, req.Method) {
+			key.method = req.Method
+		}
+		rm := m.metrics(key)
+		atomic.AddInt64(&rm.inFlight, 1)
+		w, out := req.responseRecorder(out)
+		start := time.Now()
+		completed := false
+		defer func() {
+			status := w.Status()
+			// a panic which happened before anything was written becomes
+			// a 500 once it's recovered
+			if completed == false && w.status == 0 {
+				status = 500
+			}
+			m.record(rm, status, time.Since(start))
+			atomic.AddInt64(&rm.inFlight, -1)
+		}()
+		next(out, req)
+		completed = true
+	}
+}
+
+func (m *Metrics) metrics(key metricsKey) *routeMetrics {
+	if rm, exists := m.routes.Load(key); exists {
+		return rm.(*routeMetrics)
+	}
+	rm, _ := m.routes.LoadOrStore(key, &routeMetrics{buckets: make([]uint64, len(m.buckets)+1)})
+	return rm.(*routeMetrics)
+}
+
+func (m *Metrics) record(rm *routeMetrics, status int, duration time.Duration) {
+	if class := status/100 - 1; class >= 0 && class < len(rm.codes) {
+		atomic.AddUint64(&rm.codes[class], 1)
+	}
+	seconds := duration.Seconds()
+	bucket := sort.SearchFloat64s(m.buckets, seconds)
+	atomic.AddUint64(&rm.buckets[bucket], 1)
+	atomic.AddUint64(&rm.sum, uint64(duration))
+}
+
+// Serves the metrics in the Prometheus text exposition format
+func (m *Metrics) Handler(out http.ResponseWriter, req *Request) {
+	out.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(out)
+}
+
+// Writes the metrics in the Prometheus text exposition format, sorted by
+// route and method
+func (m *Metrics) WriteTo(out io.Writer) (int64, error) {
+	var keys []metricsKey
+	m.routes.Range(func(key, value interface{}) bool {
+		keys = append(keys, key.(metricsKey))
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+	all := make([]*routeMetrics, len(keys))
+	labels := make([]string, len(keys))
+	for i, key := range keys {
+		rm, _ := m.routes.Load(key)
+		all[i] = rm.(*routeMetrics)
+		labels[i] = `route="` + labelEscaper.Replace(key.route) + `",method="` + labelEscaper.Replace(key.method) + `"`
+	}
+
+	var b strings.Builder
+	b.WriteString("# HELP router_requests_total Requests handled, by route, method and status class.\n")
+	b.WriteString("# TYPE router_requests_total counter\n")
+	for i, rm := range all {
+		for class := range rm.codes {
+			if count := atomic.LoadUint64(&rm.codes[class]); count != 0 {
+				b.WriteString("router_requests_total{" + labels[i] + `,code="` + strconv.Itoa(class+1) + `xx"} ` + strconv.FormatUint(count, 10) + "\n")
+			}
+		}
+	}
+
+	b.WriteString("# HELP router_request_duration_seconds Time taken to handle requests, by route and method.\n")
+	b.WriteString("# TYPE router_request_duration_seconds histogram\n")
+	for i, rm := range all {
+		// buckets are incremented before the sum and count, so using the
+		// total of the buckets as the count keeps the histogram consistent
+		sum := atomic.LoadUint64(&rm.sum)
+		cumulative := uint64(0)
+		for j, le := range m.buckets {
+			cumulative += atomic.LoadUint64(&rm.buckets[j])
+			b.WriteString("router_request_duration_seconds_bucket{" + labels[i] + `,le="` + strconv.FormatFloat(le, 'g', -1, 64) + `"} ` + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		cumulative += atomic.LoadUint64(&rm.buckets[len(m.buckets)])
+		b.WriteString("router_request_duration_seconds_bucket{" + labels[i] + `,le="+Inf"} ` + strconv.FormatUint(cumulative, 10) + "\n")
+		b.WriteString("router_request_duration_seconds_sum{" + labels[i] + "} " + strconv.FormatFloat(time.Duration(sum).Seconds(), 'g', -1, 64) + "\n")
+		b.WriteString("router_request_duration_seconds_count{" + labels[i] + "} " + strconv.FormatUint(cumulative, 10) + "\n")
+	}
+
+	b.WriteString("# HELP router_requests_in_flight Requests being handled, by route and method.\n")
+	b.WriteString("# TYPE router_requests_in_flight gauge\n")
+	for i, rm := range all {
+		b.WriteString("router_requests_in_flight{" + labels[i] + "} " + strconv.FormatInt(atomic.LoadInt64(&rm.inFlight), 10) + "\n")
+	}
+	n, err := io.WriteString(out, b.String())
+	return int64(n), err
+}
@@ -0,0 +1,63 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type contextKey int
+
+const requestKey contextKey = 0
+
+// Routes every method for prefix, and everything under it, to handler. As
+// with http.StripPrefix, the prefix is removed from the request's path before
+// handler is called. The prefix can contain parameters.
+func (r *Router) Mount(prefix string, handler http.Handler, middleware ...Middleware) {
+	prefix = cleanPrefix(prefix)
+	r.All(prefix+"/*", mount(prefix, handler), middleware...)
+}
+
+func (g *Group) Mount(prefix string, handler http.Handler, middleware ...Middleware) {
+	prefix = cleanPrefix(prefix)
+	g.All(prefix+"/*", mount(g.prefix+prefix, handler), middleware...)
+}
+
+// The *Request, and thus its params, of a request routed to a mounted
+// http.Handler. Unlike the requests given to Handlers, it isn't pooled, so
+// it remains valid for as long as the context is kept.
+func FromContext(ctx context.Context) (*Request, bool) {
+	req, ok := ctx.Value(requestKey).(*Request)
+	return req, ok
+}
+
+func mount(prefix string, handler http.Handler) Handler {
+	segments := strings.Count(prefix, "/")
+	return func(out http.ResponseWriter, req *Request) {
+		hr := req.Request.WithContext(context.WithValue(req.Context(), requestKey, req.detach()))
+		u := new(url.URL)
+		*u = *req.URL
+		// the prefix was matched against the cleaned path, so that's what
+		// it's stripped from
+		u.Path = stripSegments(req.cleanPath(), segments)
+		if len(u.RawPath) != 0 {
+			u.RawPath = stripSegments(cleanPath(u.RawPath), segments)
+		}
+		hr.URL = u
+		handler.ServeHTTP(out, hr)
+	}
+}
+
+// removes the first n segments from path, keeping the leading slash of
+// whatever remains
+func stripSegments(path string, n int) string {
+	for i := 0; i < n && len(path) > 0; i++ {
+		next := strings.IndexByte(path[1:], '/')
+		if next == -1 {
+			return ""
+		}
+		path = path[next+1:]
+	}
+	return path
+}
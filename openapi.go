@@ -0,0 +1,222 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Documents a route for OpenAPI. Schemas are JSON schemas, given as any
+// value which encodes to one, such as a map or a json.RawMessage.
+type Doc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// descriptions of the path parameters, by name
+	Params map[string]string
+	// the schema of the JSON request body
+	Request interface{}
+	// the schemas of the JSON response bodies, by status code. A nil schema
+	// documents a response without a body.
+	Responses map[int]interface{}
+}
+
+// the methods which OpenAPI path items can have
+var openAPIMethods = map[string]string{
+	"GET":     "get",
+	"PUT":     "put",
+	"POST":    "post",
+	"DELETE":  "delete",
+	"OPTIONS": "options",
+	"HEAD":    "head",
+	"PATCH":   "patch",
+}
+
+// the schemas of the named constraints
+var constraintSchemas = map[string]map[string]interface{}{
+	"int":   {"type": "integer"},
+	"uint":  {"type": "integer", "minimum": 0},
+	"uuid":  {"type": "string", "format": "uuid"},
+	"alpha": {"type": "string", "pattern": "^[A-Za-z]+$"},
+	"hex":   {"type": "string", "pattern": "^[0-9A-Fa-f]+$"},
+	"date":  {"type": "string", "format": "date"},
+}
+
+type openAPIOperation struct {
+	OperationID string                     `json:"operationId,omitempty"`
+	Summary     string                     `json:"summary,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Tags        []string                   `json:"tags,omitempty"`
+	Parameters  []openAPIParameter         `json:"parameters,omitempty"`
+	RequestBody *openAPIBody               `json:"requestBody,omitempty"`
+	Responses   map[string]openAPIResponse `json:"responses,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string                 `json:"name"`
+	In          string                 `json:"in"`
+	Description string                 `json:"description,omitempty"`
+	Required    bool                   `json:"required"`
+	Schema      map[string]interface{} `json:"schema"`
+}
+
+type openAPIBody struct {
+	Content map[string]openAPIMedia `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                  `json:"description"`
+	Content     map[string]openAPIMedia `json:"content,omitempty"`
+}
+
+type openAPIMedia struct {
+	Schema interface{} `json:"schema"`
+}
+
+// Generates an OpenAPI 3.1 document of the routes. Each variant of a pattern
+// with optional segments is its own path. Prefix and glob routes can't be
+// described, since path parameters can't contain a /, and are left out, as
+// are methods which OpenAPI doesn't support, such as PURGE. Names used by a
+// single route become the operationId. An error is returned when two routes
+// end up as the same OpenAPI path and method, or when two paths differ only
+// by the names of their parameters.
+func (r *Router) OpenAPI(title, version string) ([]byte, error) {
+	t := r.table()
+	uses := make(map[string]int)
+	for _, reg := range t.registrations {
+		uses[reg.action.Name]++
+	}
+
+	paths := make(map[string]map[string]*openAPIOperation)
+	// the path of each parameterless template and the pattern of each operation
+	templates := make(map[string]string)
+	patterns := make(map[string]string)
+	for _, reg := range t.registrations {
+		method, ok := openAPIMethods[reg.method]
+		if ok == false {
+			continue
+		}
+		path, params, ok := r.openAPIPath(reg.path)
+		if ok == false {
+			continue
+		}
+		key := templateKey(path)
+		if existing, exists := templates[key]; exists && existing != path {
+			return nil, fmt.Errorf("router: OpenAPI paths %s and %s only differ by their parameter names", existing, path)
+		}
+		templates[key] = path
+		operationKey := reg.method + " " + path
+		if existing, exists := patterns[operationKey]; exists {
+			return nil, fmt.Errorf("router: %s %s and %s %s are both %s in OpenAPI", reg.method, existing, reg.method, reg.pattern, operationKey)
+		}
+		patterns[operationKey] = reg.pattern
+		operation := &openAPIOperation{Parameters: params}
+		if uses[reg.action.Name] == 1 {
+			operation.OperationID = reg.action.Name
+		}
+		if doc := reg.action.doc; doc != nil {
+			operation.document(doc)
+		}
+		item, exists := paths[path]
+		if exists == false {
+			item = make(map[string]*openAPIOperation)
+			paths[path] = item
+		}
+		item[method] = operation
+	}
+
+	return json.MarshalIndent(map[string]interface{}{
+		"openapi": "3.1.0",
+		"info":    map[string]string{"title": title, "version": version},
+		"paths":   paths,
+	}, "", "  ")
+}
+
+func (o *openAPIOperation) document(doc *Doc) {
+	o.Summary, o.Description, o.Tags = doc.Summary, doc.Description, doc.Tags
+	for i, param := range o.Parameters {
+		o.Parameters[i].Description = doc.Params[param.Name]
+	}
+	if doc.Request != nil {
+		o.RequestBody = &openAPIBody{Content: jsonContent(doc.Request)}
+	}
+	if len(doc.Responses) != 0 {
+		o.Responses = make(map[string]openAPIResponse, len(doc.Responses))
+		for status, schema := range doc.Responses {
+			response := openAPIResponse{Description: http.StatusText(status)}
+			if schema != nil {
+				response.Content = jsonContent(schema)
+			}
+			o.Responses[strconv.Itoa(status)] = response
+		}
+	}
+}
+
+func jsonContent(schema interface{}) map[string]openAPIMedia {
+	return map[string]openAPIMedia{"application/json": {Schema: schema}}
+}
+
+// Turns a route's path into an OpenAPI path template, such as
+// /users/{id}.json, along with its parameters. ok is false for prefix and
+// glob routes, which can't be expressed as a template.
+func (r *Router) openAPIPath(path string) (string, []openAPIParameter, bool) {
+	trimmed := strings.Trim(path, "/")
+	if len(trimmed) == 0 {
+		return "/", nil, true
+	}
+	var params []openAPIParameter
+	var b strings.Builder
+	for _, part := range strings.Split(trimmed, "/") {
+		b.WriteByte('/')
+		if _, _, ok := splitCatchAll(part); ok {
+			return "", nil, false
+		}
+		if strings.IndexByte(part, ':') == -1 {
+			b.WriteString(part)
+			continue
+		}
+		tokens, _ := r.parseSegment(part)
+		for _, t := range tokens {
+			if len(t.variable) == 0 {
+				b.WriteString(t.literal)
+				continue
+			}
+			b.WriteString("{" + t.variable + "}")
+			params = append(params, openAPIParameter{Name: t.variable, In: "path", Required: true, Schema: r.constraintSchema(t.constraint)})
+		}
+	}
+	return b.String(), params, true
+}
+
+// the template without its parameter names, /users/{id} becomes /users/{}
+func templateKey(path string) string {
+	var b strings.Builder
+	for len(path) != 0 {
+		start := strings.IndexByte(path, '{')
+		end := strings.IndexByte(path, '}')
+		if start == -1 || end < start {
+			b.WriteString(path)
+			break
+		}
+		b.WriteString(path[:start+1])
+		path = path[end:]
+	}
+	return b.String()
+}
+
+func (r *Router) constraintSchema(c *constraint) map[string]interface{} {
+	schema := map[string]interface{}{"type": "string"}
+	if c == nil {
+		return schema
+	}
+	if builtin, exists := constraintSchemas[c.source]; exists {
+		return builtin
+	}
+	// custom named constraints can't be described
+	if _, named := r.namedConstraint(c.source); named == false {
+		schema["pattern"] = c.source
+	}
+	return schema
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"errors"
+	"strings"
+)
+
+// One of the paths an optional pattern expands to. defaults holds the values
+// of the omitted segments which have one.
+type variant struct {
+	path     string
+	defaults []KeyValue
+}
+
+// Expands a pattern with optional trailing segments, such as
+// /reports/:year/:month? or /list/:page(int)=1, into every path it can match,
+// shortest first. A pattern without optional segments expands to itself.
+func (r *Router) expandOptional(path string) ([]variant, error) {
+	trimmed := strings.TrimPrefix(path, "/")
+	trailing := ""
+	if strings.HasSuffix(trimmed, "/") {
+		trimmed, trailing = trimmed[:len(trimmed)-1], "/"
+	}
+	if len(trimmed) == 0 {
+		return []variant{{path: path}}, nil
+	}
+
+	parts := strings.Split(trimmed, "/")
+	required := len(parts)
+	var defaults []KeyValue
+	for i, part := range parts {
+		segment, value, optional, hasDefault := splitOptional(part)
+		if optional == false {
+			if required != len(parts) {
+				return nil, errors.New("only the last segments can be optional")
+			}
+			continue
+		}
+		if required == len(parts) {
+			required = i
+		}
+		parts[i] = segment
+		kv := KeyValue{}
+		if hasDefault {
+			// invalid segments are reported once the pattern is validated
+			tokens, err := r.parseSegment(segment)
+			names := tokenVariables(tokens)
+			if err == nil && len(names) != 1 {
+				return nil, errors.New("only a segment with a single parameter can have a default")
+			}
+			if len(names) == 1 {
+				kv = KeyValue{names[0], value}
+			}
+		}
+		defaults = append(defaults, kv)
+	}
+	if required == len(parts) {
+		return []variant{{path: path}}, nil
+	}
+
+	variants := make([]variant, 0, len(parts)-required+1)
+	for i := required; i <= len(parts); i++ {
+		v := variant{path: "/" + strings.Join(parts[:i], "/") + trailing}
+		if i == 0 {
+			v.path = "/"
+		}
+		for _, kv := range defaults[i-required:] {
+			if len(kv.key) != 0 {
+				v.defaults = append(v.defaults, kv)
+			}
+		}
+		variants = append(variants, v)
+	}
+	return variants, nil
+}
+
+// splits the trailing ? or the =default marker off of a segment with
+// parameters, such as :month?, v:version? or :page(int)=1
+func splitOptional(part string) (segment string, value string, optional bool, hasDefault bool) {
+	start := strings.IndexByte(part, ':')
+	if start == -1 {
+		return part, "", false, false
+	}
+	for i := start; i < len(part); i++ {
+		switch part[i] {
+		case '(':
+			end := closingParen(part, i)
+			if end == -1 {
+				return part, "", false, false
+			}
+			i = end
+		case '=':
+			return part[:i], part[i+1:], true, true
+		}
+	}
+	if l := len(part); l > 2 && part[l-1] == '?' {
+		return part[:l-1], "", true, false
+	}
+	return part, "", false, false
+}
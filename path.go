@@ -0,0 +1,60 @@
+package router
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"gopkg.in/karlseguin/params.v2"
+)
+
+var redirectAction = &Action{Handler: redirectHandler}
+
+// Looks up the route for the cleaned path. Also returns the canonical form of
+// the path, which, unless the policy is PathLenient, has the same trailing
+// slash as the matched route.
+func (r *Router) route(t *table, method, p string) (*params.Params, *Action, string) {
+	clean := cleanPath(p)
+	params, action := r.lookup(t, method, clean)
+	if r.config.pathPolicy == PathLenient || r.isMiss(action) || action.wildcard || clean == "/" {
+		return params, action, clean
+	}
+	if slash := clean[len(clean)-1] == '/'; slash && action.slash == false {
+		clean = clean[:len(clean)-1]
+	} else if slash == false && action.slash {
+		clean += "/"
+	}
+	return params, action, clean
+}
+
+// Collapses repeated slashes and resolves . and .. segments while keeping
+// any trailing slash. Clean paths are returned as-is.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	clean := path.Clean(p)
+	if p[len(p)-1] == '/' && clean != "/" {
+		if len(clean) == len(p)-1 && p[:len(clean)] == clean {
+			return p
+		}
+		return clean + "/"
+	}
+	return clean
+}
+
+// The canonical path is decoded, so it's escaped again. A leading // or /\
+// would make the location a different host.
+func redirectHandler(out http.ResponseWriter, req *Request) {
+	location := &url.URL{Path: "/" + strings.TrimLeft(req.canonical, "/"), RawQuery: req.URL.RawQuery}
+	out.Header().Set("Location", location.String())
+	if req.Method == "GET" || req.Method == "HEAD" {
+		out.WriteHeader(301)
+	} else {
+		out.WriteHeader(308)
+	}
+}
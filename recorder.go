@@ -0,0 +1,83 @@
+package router
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Wraps a response writer to capture the status and the size of the body.
+// The status is only recorded once the underlying writer accepted it, so a
+// WriteHeader which panics leaves the response unwritten.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *recorder) WriteHeader(status int) {
+	w.ResponseWriter.WriteHeader(status)
+	// informational responses are followed by the real one
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+}
+
+func (w *recorder) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	if w.status == 0 {
+		w.status = 200
+	}
+	w.size += n
+	return n, err
+}
+
+// so that io.Copy, and http.ServeContent, can still use sendfile
+func (w *recorder) ReadFrom(src io.Reader) (int64, error) {
+	readerFrom, ok := w.ResponseWriter.(io.ReaderFrom)
+	if ok == false {
+		// hide ReadFrom, or io.Copy would call back into it
+		return io.Copy(struct{ io.Writer }{w}, src)
+	}
+	n, err := readerFrom.ReadFrom(src)
+	if w.status == 0 {
+		w.status = 200
+	}
+	w.size += int(n)
+	return n, err
+}
+
+func (w *recorder) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := w.ResponseWriter.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (w *recorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("router: the response writer doesn't support hijacking")
+}
+
+// for http.ResponseController
+func (w *recorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// the status which was sent, 200 if the handler didn't set one
+func (w *recorder) Status() int {
+	if w.status == 0 {
+		return 200
+	}
+	return w.status
+}
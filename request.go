@@ -4,31 +4,118 @@ import (
 	"gopkg.in/karlseguin/params.v2"
 	"net/http"
 	"net/url"
+	"sync"
 )
 
+var requestPool = sync.Pool{
+	New: func() interface{} { return new(Request) },
+}
+
 type Request struct {
 	*http.Request
-	query  url.Values
-	params *params.Params
+	query     url.Values
+	params    *params.Params
+	action    *Action
+	router    *Router
+	table     *table
+	recorder  *recorder // &response when created by ServeHTTP
+	response  recorder
+	canonical string
 }
 
+// Returns the default value of an omitted optional segment when the
+// parameter isn't in the path
 func (r *Request) Param(key string) string {
-	value, _ := r.params.Get(key)
-	return value
+	if value, ok := r.params.Get(key); ok {
+		return value
+	}
+	if r.action != nil {
+		for _, kv := range r.action.defaults {
+			if kv.key == key {
+				return kv.value
+			}
+		}
+	}
+	return ""
+}
+
+// The route the request was matched to, nil if it wasn't matched
+func (r *Request) Route() *Route {
+	if r.action == nil {
+		return nil
+	}
+	return r.action.route
+}
+
+// Whether the response's header has been sent. Always false for requests
+// which weren't created by ServeHTTP.
+func (r *Request) Written() bool {
+	return r.recorder != nil && r.recorder.status != 0
+}
+
+// The recorder ServeHTTP wrapped the response in, so that middleware doesn't
+// wrap it again. Requests which didn't come from ServeHTTP get a new one,
+// along with the writer to use in place of out.
+func (r *Request) responseRecorder(out http.ResponseWriter) (*recorder, http.ResponseWriter) {
+	if r.recorder != nil {
+		return r.recorder, out
+	}
+	w := &recorder{ResponseWriter: out}
+	return w, w
+}
+
+// The path routing was done with
+func (r *Request) cleanPath() string {
+	if r.canonical == "" {
+		return cleanPath(r.URL.Path)
+	}
+	return r.canonical
 }
 
 func (r *Request) Query(key string) string {
-	return r.query.Get(key)
+	return r.queryValues().Get(key)
 }
 
 func (r *Request) MQuery(key string) []string {
-	return r.query[key]
+	return r.queryValues()[key]
+}
+
+// the query string is parsed the first time it's needed
+func (r *Request) queryValues() url.Values {
+	if r.query == nil {
+		r.query = r.URL.Query()
+	}
+	return r.query
 }
 
 func NewRequest(req *http.Request, params *params.Params) *Request {
 	return &Request{
 		Request: req,
 		params:  params,
-		query:   req.URL.Query(),
 	}
 }
+
+// Requests created by ServeHTTP are pooled and must not be used once the
+// handler returns
+func checkoutRequest(req *http.Request, params *params.Params) *Request {
+	r := requestPool.Get().(*Request)
+	r.Request, r.params = req, params
+	return r
+}
+
+// A copy which owns its params, for use once the handler returns
+func (r *Request) detach() *Request {
+	l := 0
+	r.params.Each(func(string, string) { l++ })
+	p := EmptyParams
+	if l != 0 {
+		p = params.New(l)
+		r.params.Each(func(key, value string) { p.Set(key, value) })
+	}
+	return &Request{Request: r.Request, params: p, action: r.action, router: r.router, table: r.table, canonical: r.canonical}
+}
+
+func (r *Request) release() {
+	*r = Request{}
+	requestPool.Put(r)
+}
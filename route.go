@@ -0,0 +1,38 @@
+package router
+
+import (
+	"strings"
+)
+
+// A registered route. Each method a pattern is registered for has its own
+// Route.
+type Route struct {
+	Name   string
+	Method string
+	// the path as it was registered, such as /users/:id(uint)
+	Pattern string
+	// set when the route ends with a prefix, such as /users/ad*, or a glob,
+	// such as /users/*, and thus matches any path under it
+	Prefix bool
+	Glob   bool
+	meta   map[string]interface{}
+}
+
+func newRoute(name, method, pattern string, meta map[string]interface{}) *Route {
+	route := &Route{Name: name, Method: method, Pattern: pattern, meta: meta}
+	last := strings.TrimSuffix(pattern, "/")
+	last = last[strings.LastIndexByte(last, '/')+1:]
+	if prefix, _, ok := splitCatchAll(last); ok {
+		route.Prefix, route.Glob = len(prefix) != 0, len(prefix) == 0
+	}
+	return route
+}
+
+// The metadata registered with the route under key, or nil. Can be called on
+// a nil Route.
+func (r *Route) Meta(key string) interface{} {
+	if r == nil {
+		return nil
+	}
+	return r.meta[key]
+}
@@ -1,39 +1,202 @@
 package router
 
 import (
-	"regexp"
+	"sort"
+	"strings"
 )
 
+// A node of a compressed radix tree. Static segments are stored with a
+// trailing slash, so "users/list" is the key "users/list/", and edges can
+// span several segments. Nodes whose key ends with a slash, and the roots,
+// sit on a segment boundary. Only those have actions, params and prefixes.
 type RoutePart struct {
-	variables []string
-	action    *Action
-	glob      bool
-	parts     map[string]*RoutePart
-	params    []Param
-	prefixes  []Prefix
+	prefix        string
+	indices       string
+	children      []*RoutePart
+	variables     []string
+	action        *Action
+	globAction    *Action
+	globVariable  string
+	globVariables []string
+	params        []Param
+	prefixes      []Prefix
 }
 
 func newRoutePart() *RoutePart {
-	return &RoutePart{
-		parts: make(map[string]*RoutePart),
+	return &RoutePart{}
+}
+
+// A copy of rp which can be changed without affecting rp
+func (rp *RoutePart) clone() *RoutePart {
+	c := *rp
+	c.children = append([]*RoutePart(nil), rp.children...)
+	c.params = append([]Param(nil), rp.params...)
+	c.prefixes = append([]Prefix(nil), rp.prefixes...)
+	return &c
+}
+
+// Returns the node for the static key below rp, creating it and splitting
+// existing edges as needed. rp must be a copy, the nodes along the way are
+// copied.
+func (rp *RoutePart) static(key string) *RoutePart {
+	for len(key) > 0 {
+		i := strings.IndexByte(rp.indices, key[0])
+		if i == -1 {
+			child := &RoutePart{prefix: key}
+			rp.indices += key[:1]
+			rp.children = append(rp.children, child)
+			return child
+		}
+		child := rp.children[i].clone()
+		rp.children[i] = child
+		l := commonPrefix(child.prefix, key)
+		if l < len(child.prefix) {
+			split := &RoutePart{prefix: child.prefix[:l], indices: child.prefix[l : l+1], children: []*RoutePart{child}}
+			child.prefix = child.prefix[l:]
+			rp.children[i] = split
+			child = split
+		}
+		key, rp = key[l:], child
+	}
+	return rp
+}
+
+// Consumes the node's prefix from path. The end of path counts as a slash,
+// so "users" reaches the node for "users/".
+func (rp *RoutePart) consume(path string) (string, bool) {
+	p := rp.prefix
+	if l := len(p); len(path) >= l {
+		if path[:l] == p {
+			return path[l:], true
+		}
+		return "", false
+	}
+	if len(path)+1 == len(p) && p[len(path)] == '/' && p[:len(path)] == path {
+		return "", true
 	}
+	return "", false
+}
+
+// the static child whose prefix starts with c, or nil
+func (rp *RoutePart) child(c byte) *RoutePart {
+	for i := 0; i < len(rp.indices); i++ {
+		if rp.indices[i] == c {
+			return rp.children[i]
+		}
+	}
+	return nil
+}
+
+func (rp *RoutePart) boundary() bool {
+	l := len(rp.prefix)
+	return l == 0 || rp.prefix[l-1] == '/'
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
 }
 
 type Param struct {
-	constraint *regexp.Regexp
+	constraint *constraint
 	route      *RoutePart
 	suffix     string
+	key        string
+	// the segment as first registered, for Dump
+	segment string
+	// set when the segment is more than a parameter with an optional postfix
+	tokens []token
+	count  int
+}
+
+func newParam(segment string, tokens []token, route *RoutePart, key string) Param {
+	param := Param{route: route, key: key, segment: segment}
+	if first := tokens[0]; len(first.variable) != 0 && len(tokens) < 3 {
+		param.constraint = first.constraint
+		if len(tokens) == 2 {
+			param.suffix = tokens[1].literal
+		}
+		return param
+	}
+	param.tokens = tokens
+	for _, t := range tokens {
+		if len(t.variable) != 0 {
+			param.count++
+		}
+	}
+	return param
+}
+
+// Params are kept ordered by precedence. Params of equal precedence, such as
+// two constraints which may accept the same value, stay in the order they
+// were registered in.
+func (rp *RoutePart) addParam(param Param) {
+	rp.params = append(rp.params, param)
+	sort.SliceStable(rp.params, func(i, j int) bool {
+		return rp.params[i].before(rp.params[j])
+	})
 }
 
-func newParam(constraint *regexp.Regexp, route *RoutePart, suffix string) Param {
-	return Param{
-		route:      route,
-		suffix:     suffix,
-		constraint: constraint,
+// Adds a glob, when prefix is empty, or a prefix. Prefixes are kept longest
+// first. Returns false when it already exists.
+func (rp *RoutePart) addCatchAll(prefix string, variable string, variables []string, action *Action) bool {
+	if len(prefix) == 0 {
+		if rp.globAction != nil {
+			return false
+		}
+		rp.globAction, rp.globVariable, rp.globVariables = action, variable, variables
+		return true
 	}
+	for _, existing := range rp.prefixes {
+		if existing.value == prefix {
+			return false
+		}
+	}
+	rp.prefixes = append(rp.prefixes, Prefix{value: prefix, variable: variable, variables: variables, action: action})
+	sort.SliceStable(rp.prefixes, func(i, j int) bool {
+		return len(rp.prefixes[i].value) > len(rp.prefixes[j].value)
+	})
+	return true
+}
+
+// Constrained params come first, then those with the most literal text
+func (p Param) before(other Param) bool {
+	if a, b := p.constrained(), other.constrained(); a != b {
+		return a
+	}
+	return p.literals() > other.literals()
+}
+
+func (p Param) constrained() bool {
+	if p.tokens == nil {
+		return p.constraint != nil
+	}
+	for _, t := range p.tokens {
+		if t.constraint != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// the length of the literal text the segment must contain
+func (p Param) literals() int {
+	if p.tokens == nil {
+		return len(p.suffix)
+	}
+	l := 0
+	for _, t := range p.tokens {
+		l += len(t.literal)
+	}
+	return l
 }
 
 type Prefix struct {
-	value  string
-	action *Action
+	value     string
+	variable  string
+	variables []string
+	action    *Action
 }
@@ -1,17 +1,41 @@
 package router
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
-	"regexp"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"gopkg.in/karlseguin/params.v2"
 	"gopkg.in/karlseguin/scratch.v2"
 )
 
 type Action struct {
-	Name    string
-	Handler Handler
+	Name     string
+	Handler  Handler
+	slash    bool
+	wildcard bool
+	defaults []KeyValue
+	doc      *Doc
+	route    *Route
+}
+
+// Describes a route to register. Name defaults to METHOD:path. Middleware
+// runs after the router's middleware. Meta is available to middleware and
+// handlers through req.Route().Meta(key).
+type Definition struct {
+	Name       string
+	Method     string
+	Path       string
+	Handler    Handler
+	Middleware []Middleware
+	Doc        *Doc
+	Meta       map[string]interface{}
 }
 
 var (
@@ -21,212 +45,429 @@ var (
 
 type Handler func(out http.ResponseWriter, req *Request)
 
+type Middleware func(Handler) Handler
+
+type prefixedAction struct {
+	prefix string
+	action *Action
+}
+
 type Router struct {
-	notFound  *Action
-	routes    map[string]*RoutePart
+	tables    atomic.Value
+	states    atomic.Value
+	lock      sync.Mutex
+	parent    *Router
+	config    *Configuration
 	ParamPool *params.Pool
 	valuePool *scratch.StringsPool
 }
 
 func New(config *Configuration) *Router {
+	c := *config
+	return newRouter(&c, params.NewPool(c.paramPoolSize, c.paramPoolCount), scratch.NewStrings(c.paramPoolSize, c.paramPoolCount))
+}
+
+func newRouter(config *Configuration, paramPool *params.Pool, valuePool *scratch.StringsPool) *Router {
 	router := &Router{
-		routes:   make(map[string]*RoutePart),
-		notFound: &Action{"", notFoundHandler},
+		config:    config,
+		ParamPool: paramPool,
+		valuePool: valuePool,
 	}
-	router.ParamPool = params.NewPool(config.paramPoolSize, config.paramPoolCount)
-	router.valuePool = scratch.NewStrings(config.paramPoolSize, config.paramPoolCount)
+	router.tables.Store(newTable())
+	router.states.Store(&state{
+		notFound:         &Action{Handler: notFoundHandler},
+		methodNotAllowed: &Action{Handler: methodNotAllowedHandler},
+		handler:          router.dispatch,
+	})
 	return router
 }
 
 func (r *Router) NotFound(handler Handler) {
-	r.notFound = &Action{"", handler}
+	r.change(func(s *state) {
+		s.notFound = &Action{Handler: handler}
+	})
+}
+
+// Called when the path exists for other methods, but not for the requested
+// one. The Allow header is set before the handler is called.
+func (r *Router) MethodNotAllowed(handler Handler) {
+	r.change(func(s *state) {
+		s.methodNotAllowed = &Action{Handler: handler}
+	})
+}
+
+// Called when a handler or middleware panics. Panics in handlers, including
+// the not found and method not allowed handlers, are recovered within the
+// middleware, so that middleware sees the response written by handler. The
+// default logs the stack and, unless the response was already started,
+// responds with a 500. Host routers use the panic handler of the router they
+// were created from, unless given one of their own.
+func (r *Router) PanicHandler(handler func(out http.ResponseWriter, req *Request, recovered interface{})) {
+	r.change(func(s *state) {
+		s.panicHandler = handler
+	})
 }
 
-func (r *Router) Add(method, path string, handler Handler) {
-	r.AddNamed(method+":"+path, method, path, handler)
+// Middleware wraps every request, including those which end up in the not
+// found or method not allowed handlers. The first middleware is the outermost.
+func (r *Router) Use(middleware ...Middleware) {
+	r.change(func(s *state) {
+		s.middleware = append(s.middleware[:len(s.middleware):len(s.middleware)], middleware...)
+		s.handler = chain(r.dispatch, s.middleware)
+	})
 }
 
-func (r *Router) AddNamed(name, method, path string, handler Handler) {
-	if method == "ALL" {
-		for _, m := range AllMethods {
-			r.AddNamed(name, m, path, handler)
+func (r *Router) Add(method, path string, handler Handler, middleware ...Middleware) {
+	r.AddNamed(method+":"+path, method, path, handler, middleware...)
+}
+
+// Panics on an invalid pattern. Registering a route a second time is
+// ignored. A name already used by another path still registers the route,
+// but URL keeps generating the first path. Both panic instead when the
+// router was configured with StrictRoutes. Any middleware is composed with
+// the handler, running after the router's middleware.
+func (r *Router) AddNamed(name, method, path string, handler Handler, middleware ...Middleware) {
+	r.Define(Definition{Name: name, Method: method, Path: path, Handler: handler, Middleware: middleware})
+}
+
+func (r *Router) TryAdd(method, path string, handler Handler, middleware ...Middleware) error {
+	return r.TryAddNamed(method+":"+path, method, path, handler, middleware...)
+}
+
+// Like AddNamed, but returns a *RouteError rather than panicking or silently
+// ignoring a duplicate. For "ALL", every method which isn't a duplicate is
+// still registered.
+func (r *Router) TryAddNamed(name, method, path string, handler Handler, middleware ...Middleware) error {
+	return r.TryDefine(Definition{Name: name, Method: method, Path: path, Handler: handler, Middleware: middleware})
+}
+
+// Registers a route along with its documentation. Like AddNamed, panics on
+// an invalid pattern.
+func (r *Router) Define(def Definition) {
+	if err := r.TryDefine(def); err != nil {
+		if r.config.strictRoutes || isDuplicate(err) == false {
+			panic(err)
 		}
-		return
 	}
-	rp, exists := r.routes[method]
-	if exists == false {
-		rp = newRoutePart()
-		r.routes[method] = rp
-	}
-	r.add(rp, path, &Action{name, handler})
 }
 
-func (r *Router) All(path string, handler Handler) {
+func (r *Router) TryDefine(def Definition) error {
+	return r.update(func(b *Builder) error {
+		return b.TryDefine(def)
+	})
+}
+
+func (r *Router) All(path string, handler Handler, middleware ...Middleware) {
 	for _, method := range AllMethods {
-		r.Add(method, path, handler)
+		r.Add(method, path, handler, middleware...)
 	}
 }
 
-func (r *Router) AllNamed(name, path string, handler Handler) {
+func (r *Router) AllNamed(name, path string, handler Handler, middleware ...Middleware) {
 	for _, method := range AllMethods {
-		r.AddNamed(name, method, path, handler)
+		r.AddNamed(name, method, path, handler, middleware...)
 	}
 }
 
-func (r *Router) Get(path string, handler Handler) {
-	r.Add("GET", path, handler)
+func (r *Router) Get(path string, handler Handler, middleware ...Middleware) {
+	r.Add("GET", path, handler, middleware...)
+}
+
+func (r *Router) Post(path string, handler Handler, middleware ...Middleware) {
+	r.Add("POST", path, handler, middleware...)
 }
 
-func (r *Router) Post(path string, handler Handler) {
-	r.Add("POST", path, handler)
+func (r *Router) Put(path string, handler Handler, middleware ...Middleware) {
+	r.Add("PUT", path, handler, middleware...)
 }
 
-func (r *Router) Put(path string, handler Handler) {
-	r.Add("PUT", path, handler)
+func (r *Router) Delete(path string, handler Handler, middleware ...Middleware) {
+	r.Add("DELETE", path, handler, middleware...)
 }
 
-func (r *Router) Delete(path string, handler Handler) {
-	r.Add("DELETE", path, handler)
+func (r *Router) Purge(path string, handler Handler, middleware ...Middleware) {
+	r.Add("PURGE", path, handler, middleware...)
 }
 
-func (r *Router) Purge(path string, handler Handler) {
-	r.Add("PURGE", path, handler)
+func (r *Router) Patch(path string, handler Handler, middleware ...Middleware) {
+	r.Add("PATCH", path, handler, middleware...)
 }
 
-func (r *Router) Patch(path string, handler Handler) {
-	r.Add("PATCH", path, handler)
+func (r *Router) Options(path string, handler Handler, middleware ...Middleware) {
+	r.Add("OPTIONS", path, handler, middleware...)
 }
 
-func (r *Router) Options(path string, handler Handler) {
-	r.Add("OPTIONS", path, handler)
+func (r *Router) Head(path string, handler Handler, middleware ...Middleware) {
+	r.Add("HEAD", path, handler, middleware...)
 }
 
 func (r *Router) ServeHTTP(out http.ResponseWriter, hr *http.Request) {
-	params, action := r.Lookup(hr)
+	s := r.state()
+	router, host := r.routerFor(s, hr.Host)
+	t := router.table()
+	path := hr.URL.Path
+	params, action, canonical := router.route(t, hr.Method, path)
+	if router.config.autoMethods && hr.Method == "HEAD" && router.isMiss(action) {
+		params.Release()
+		params, action, canonical = router.route(t, "GET", path)
+		out = headResponseWriter{out}
+	}
+	if canonical != path && router.config.pathPolicy != PathLenient && router.isMiss(action) == false {
+		params.Release()
+		params, action = EmptyParams, nil
+		if router.config.pathPolicy == PathRedirect {
+			action = redirectAction
+		}
+	}
+	if host != nil && host.variables {
+		if params == EmptyParams {
+			params = r.ParamPool.Checkout()
+		}
+		host.match(hr.Host, params)
+	}
 	defer params.Release()
-	req := NewRequest(hr, params)
+	req := checkoutRequest(hr, params)
+	defer req.release()
+	w := &req.response
+	w.ResponseWriter = out
+	req.router = router
+	req.table = t
+	req.action = action
+	req.canonical = canonical
+	req.recorder = w
+	defer r.recover(w, req)
+	s.handler(w, req)
+}
 
-	if action == nil || action.Handler == nil {
-		r.notFound.Handler(out, req)
+func (r *Router) dispatch(out http.ResponseWriter, req *Request) {
+	if req.router != r {
+		req.router.state().handler(out, req)
+		return
+	}
+	defer r.recover(out, req)
+	action := req.action
+	if r.isMiss(action) {
+		s := r.state()
+		if allowed := r.allowed(req.table, req.Method, req.URL.Path); len(allowed) != 0 {
+			out.Header().Set("Allow", strings.Join(allowed, ", "))
+			if r.config.autoMethods && req.Method == "OPTIONS" {
+				out.WriteHeader(204)
+				return
+			}
+			s.methodNotAllowed.Handler(out, req)
+			return
+		}
+		r.notFoundFor(req.table, s, req.cleanPath()).Handler(out, req)
 		return
 	}
 	action.Handler(out, req)
 }
 
+func (r *Router) addNotFound(prefix string, handler Handler) {
+	r.update(func(b *Builder) error {
+		b.addNotFound(prefix, handler)
+		return nil
+	})
+}
+
+func (r *Router) notFoundFor(t *table, s *state, path string) *Action {
+	for _, notFound := range t.notFounds {
+		prefix := notFound.prefix
+		if strings.HasPrefix(path, prefix) && (len(path) == len(prefix) || path[len(prefix)] == '/') {
+			return notFound.action
+		}
+	}
+	return s.notFound
+}
+
+// the methods, other than method, which have a route for path
+func (r *Router) allowed(t *table, method string, path string) []string {
+	var allowed []string
+	for m := range t.routes {
+		if m == method {
+			continue
+		}
+		params, action, canonical := r.route(t, m, path)
+		params.Release()
+		if r.isMiss(action) == false && (canonical == path || r.config.pathPolicy != PathStrict) {
+			allowed = append(allowed, m)
+		}
+	}
+	if r.config.autoMethods && len(allowed) != 0 {
+		if method != "HEAD" && contains(allowed, "GET") && contains(allowed, "HEAD") == false {
+			allowed = append(allowed, "HEAD")
+		}
+		if contains(allowed, "OPTIONS") == false {
+			allowed = append(allowed, "OPTIONS")
+		}
+	}
+	if len(allowed) > 1 {
+		sort.Strings(allowed)
+	}
+	return allowed
+}
+
+func newAction(name, path string, handler Handler) *Action {
+	l := len(path)
+	return &Action{
+		Name:     name,
+		Handler:  handler,
+		slash:    l > 1 && path[l-1] == '/',
+		wildcard: l > 0 && path[l-1] == '*',
+	}
+}
+
+func (r *Router) isMiss(action *Action) bool {
+	return action == nil || action.Handler == nil
+}
+
 func (r *Router) Lookup(req *http.Request) (*params.Params, *Action) {
 	return r.LookupByParts(req.Method, req.URL.Path)
 }
 
 func (r *Router) LookupByParts(method string, path string) (*params.Params, *Action) {
-	rp, ok := r.routes[method]
+	return r.lookup(r.table(), method, path)
+}
+
+func (r *Router) lookup(t *table, method string, path string) (*params.Params, *Action) {
+	rp, ok := t.routes[method]
 	params := EmptyParams
 	if ok == false {
 		return params, nil
 	}
-	if path == "" || path == "/" {
-		action := rp.action
-		if action == nil {
-			action = r.notFound
-		}
-		return params, action
-	}
-
-	if path[0] == '/' {
+	if len(path) != 0 && path[0] == '/' {
 		path = path[1:]
 	}
-	if path[len(path)-1] == '/' {
+	if len(path) != 0 && path[len(path)-1] == '/' {
 		path = path[:(len(path) - 1)]
 	}
 
 	values := r.valuePool.Checkout()
 	defer values.Release()
-	var action *Action
-	var glob *RoutePart
-	for {
-		original := rp
-		index := strings.Index(path, "/")
-		if index == -1 {
-			index = len(path)
-		}
-		part := path[:index]
-		if rp, ok = rp.parts[part]; ok == false {
-			if original.prefixes != nil {
-				lower := strings.ToLower(part)
-				for _, prefix := range original.prefixes {
-					if strings.HasPrefix(lower, prefix.value) {
-						rp = original
-						action = prefix.action
-						break
-					}
-				}
-				if action != nil {
-					break
-				}
+	action, variables, catchAll, rest := r.match(rp, path, values)
+	if action == nil {
+		return params, nil
+	}
+
+	if l := values.Len(); l > 0 || len(catchAll) > 0 {
+		params = r.ParamPool.Checkout()
+		if lp := len(variables); l > lp {
+			l = lp
+		}
+		// values were added deepest first
+		v := values.Values()
+		for i := 0; i < l; i++ {
+			params.Set(variables[i], v[len(v)-1-i])
+		}
+		if len(catchAll) > 0 {
+			params.Set(catchAll, rest)
+		}
+	}
+	return params, action
+}
+
+// Finds the route for path, which has no leading slash, below rp, a node on
+// a segment boundary. Static segments are tried first, then parameters
+// (constrained ones first), then prefixes and finally globs. When a branch
+// doesn't lead to a route, the next one is tried. The values of matched
+// parameters are added to values deepest first, which lets values be added
+// only once a route is found.
+func (r *Router) match(rp *RoutePart, path string, values *scratch.Strings) (action *Action, variables []string, catchAll string, rest string) {
+	if len(path) == 0 {
+		if rp.action != nil {
+			return rp.action, rp.variables, "", ""
+		}
+		if rp.globAction != nil {
+			return rp.globAction, rp.globVariables, rp.globVariable, ""
+		}
+		return nil, nil, "", ""
+	}
+
+	if child := rp.child(path[0]); child != nil {
+		if action, variables, catchAll, rest = r.matchStatic(child, path, values); action != nil {
+			return
+		}
+	}
+
+	part, remaining := path, ""
+	if index := strings.IndexByte(path, '/'); index != -1 {
+		part, remaining = path[:index], path[index+1:]
+	}
+
+	for _, param := range rp.params {
+		if param.tokens != nil {
+			var captured [maxSegmentParams]string
+			if matchTokens(param.tokens, part, captured[:param.count]) == false {
+				continue
 			}
-			l := len(part)
-			for _, param := range original.params {
-				p := part
-				if lp := len(param.suffix); lp > 0 {
-					if strings.HasSuffix(p, param.suffix) == false {
-						continue
-					}
-					p = part[:l-lp]
-				}
-				if param.constraint == nil || param.constraint.MatchString(p) {
-					rp = param.route
-					part = p
-					break
+			if action, variables, catchAll, rest = r.match(param.route, remaining, values); action != nil {
+				for i := param.count - 1; i >= 0; i-- {
+					values.Add(captured[i])
 				}
+				return
 			}
-			if rp == nil {
-				if original.glob {
-					glob = original
-				}
-				break
+			continue
+		}
+		p := part
+		if lp := len(param.suffix); lp > 0 {
+			if len(p) <= lp || strings.HasSuffix(p, param.suffix) == false {
+				continue
 			}
-			values.Add(part)
+			p = part[:len(p)-lp]
 		}
-
-		if rp == nil || len(path) == index {
-			break
+		if param.constraint != nil && param.constraint.match(p) == false {
+			continue
 		}
-		if rp.glob {
-			glob = rp
+		if action, variables, catchAll, rest = r.match(param.route, remaining, values); action != nil {
+			values.Add(p)
+			return
 		}
-		path = path[index+1:]
 	}
 
-	if rp == nil || rp.action == nil {
-		if glob == nil {
-			return params, nil
+	for _, prefix := range rp.prefixes {
+		if l := len(prefix.value); len(part) >= l && strings.EqualFold(part[:l], prefix.value) {
+			return prefix.action, prefix.variables, prefix.variable, path[l:]
 		}
-		rp = glob
 	}
-
-	if rp.action == nil && action == nil {
-		return params, nil
+	if rp.globAction != nil {
+		return rp.globAction, rp.globVariables, rp.globVariable, path
 	}
+	return nil, nil, "", ""
+}
 
-	if l := values.Len(); l > 0 {
-		params = r.ParamPool.Checkout()
-		if lp := len(rp.variables); l > lp {
-			l = lp
+// Follows static edges from rp until a segment boundary is reached
+func (r *Router) matchStatic(rp *RoutePart, path string, values *scratch.Strings) (*Action, []string, string, string) {
+	for {
+		remaining, ok := rp.consume(path)
+		if ok == false {
+			return nil, nil, "", ""
 		}
-		v := values.Values()
-		for i := 0; i < l; i++ {
-			params.Set(rp.variables[i], v[i])
+		if rp.boundary() {
+			return r.match(rp, remaining, values)
 		}
+		c := byte('/')
+		if len(remaining) != 0 {
+			c = remaining[0]
+		}
+		if rp = rp.child(c); rp == nil {
+			return nil, nil, "", ""
+		}
+		path = remaining
 	}
-	if action == nil {
-		action = rp.action
-	}
-	return params, action
 }
 
-func (r *Router) add(rp *RoutePart, path string, action *Action) {
+// Adds the route to a copy of root, leaving root, which requests might be
+// using, untouched. Only the nodes along the route's path are copied. ok is
+// false when the route already exists.
+func (r *Router) add(root *RoutePart, path string, action *Action) (*RoutePart, bool) {
+	root = root.clone()
+	rp := root
 	if path == "" || path == "/" {
+		if rp.action != nil {
+			return root, false
+		}
 		rp.action = action
-		return
+		return root, true
 	}
 
 	if path[0] == '/' {
@@ -236,72 +477,177 @@ func (r *Router) add(rp *RoutePart, path string, action *Action) {
 		path = path[:(len(path) - 1)]
 	}
 
-	variables := make([]string, 0, 1)
+	// consecutive static segments are added to the tree as a single key
+	var variables []string
+	static := ""
 	parts := strings.Split(path, "/")
 	for _, part := range parts {
-		if part[len(part)-1] == '*' {
-			p := strings.ToLower(part[:len(part)-1])
-			if len(p) == 0 {
-				rp.glob = true
-			} else {
-				prefix := Prefix{value: p, action: action}
-				rp.prefixes = append(rp.prefixes, prefix)
-			}
-			break
+		if p, variable, ok := splitCatchAll(part); ok {
+			return root, rp.static(static).addCatchAll(strings.ToLower(p), variable, variables, action)
+		}
+		var tokens []token
+		if strings.IndexByte(part, ':') != -1 {
+			tokens, _ = r.parseSegment(part)
+		}
+		names := tokenVariables(tokens)
+		if len(names) == 0 {
+			static += part + "/"
+			continue
 		}
+		rp, static = rp.static(static), ""
+		variables = append(variables, names...)
+		key := tokensKey(tokens)
 		var sub *RoutePart
-		if part[0] == ':' {
-			var constraint *regexp.Regexp
-			var suffix string
-			variable := part[1:]
-			if i := strings.IndexByte(variable, ':'); i != -1 {
-				suffix = variable[i+1:]
-				variable = variable[:i]
-			}
-			l := len(variable) - 1
-			if variable[l] == ')' {
-				if start := strings.IndexByte(variable, '('); start != -1 {
-					constraint = regexp.MustCompile(variable[start+1 : l])
-					variable = variable[:start]
-				}
-			}
-			variables = append(variables, variable)
-			for _, param := range rp.params {
-				if param.constraint == nil && constraint == nil && len(param.suffix) == 0 && len(suffix) == 0 {
-					sub = param.route
-					break
-				}
-				if param.constraint == nil || constraint == nil || len(param.suffix) != 0 || len(suffix) != 0 {
-					continue
-				}
-				if param.constraint.String() == constraint.String() && param.suffix == suffix {
-					sub = param.route
-					break
-				}
-			}
-
-			if sub == nil {
-				sub = newRoutePart()
-				rp.params = append(rp.params, newParam(constraint, sub, suffix))
+		for i, param := range rp.params {
+			if param.key == key {
+				sub = param.route.clone()
+				rp.params[i].route = sub
+				break
 			}
-		} else if sub = rp.parts[part]; sub == nil {
+		}
+		if sub == nil {
 			sub = newRoutePart()
-			rp.parts[part] = sub
+			rp.addParam(newParam(part, tokens, sub, key))
 		}
 		rp = sub
 	}
+	rp = rp.static(static)
+	if rp.action != nil {
+		return root, false
+	}
 	if len(variables) > 0 {
 		rp.variables = variables
 	}
-	if rp.action == nil {
-		rp.action = action
+	rp.action = action
+	return root, true
+}
+
+// returns the pattern's variables, or an error describing why the pattern
+// is invalid
+func (r *Router) validatePattern(path string) ([]string, error) {
+	path = strings.TrimPrefix(path, "/")
+	path = strings.TrimSuffix(path, "/")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	var variables []string
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if len(part) == 0 {
+			return nil, errors.New("empty segment")
+		}
+		if _, variable, ok := splitCatchAll(part); ok {
+			if i != len(parts)-1 {
+				return nil, errors.New("only the last segment can contain *")
+			}
+			if strings.ContainsAny(variable, ":*()") {
+				return nil, fmt.Errorf("invalid variable name %q", variable)
+			}
+			if len(variable) > 0 {
+				variables = append(variables, variable)
+			}
+			continue
+		}
+		if strings.IndexByte(part, ':') == -1 {
+			continue
+		}
+		tokens, err := r.parseSegment(part)
+		if err != nil {
+			return nil, err
+		}
+		variables = append(variables, tokenVariables(tokens)...)
+	}
+	return variables, nil
+}
+
+// splits a prefix*variable segment, where both prefix and variable are
+// optional. ok is false if the segment isn't a prefix or glob.
+func splitCatchAll(part string) (prefix string, variable string, ok bool) {
+	if strings.IndexByte(part, ':') != -1 {
+		if part[0] != ':' || part[len(part)-1] != '*' {
+			return "", "", false
+		}
+		return part[:len(part)-1], "", true
+	}
+	i := strings.IndexByte(part, '*')
+	if i == -1 {
+		return "", "", false
+	}
+	return part[:i], part[i+1:], true
+}
+
+// the first value which appears more than once
+func duplicate(values []string) string {
+	for i, value := range values {
+		for _, other := range values[i+1:] {
+			if value == other {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// Deprecated: use Walk
+func (r *Router) Routes() map[string]*RoutePart {
+	return r.table().routes
+}
+
+// http.ErrAbortHandler is re-panicked, so that net/http aborts the response
+func (r *Router) recover(out http.ResponseWriter, req *Request) {
+	if recovered := recover(); recovered != nil {
+		if recovered == http.ErrAbortHandler {
+			panic(recovered)
+		}
+		r.panicHandler()(out, req, recovered)
 	}
 }
 
-func (r Router) Routes() map[string]*RoutePart {
-	return r.routes
+func (r *Router) panicHandler() func(out http.ResponseWriter, req *Request, recovered interface{}) {
+	for router := r; router != nil; router = router.parent {
+		if handler := router.state().panicHandler; handler != nil {
+			return handler
+		}
+	}
+	return defaultPanicHandler
+}
+
+func defaultPanicHandler(out http.ResponseWriter, req *Request, recovered interface{}) {
+	log.Printf("router: panic serving %s %s: %v\n%s", req.Method, req.URL.Path, recovered, debug.Stack())
+	if req.Written() == false {
+		out.WriteHeader(500)
+	}
 }
 
 func notFoundHandler(out http.ResponseWriter, req *Request) {
 	out.WriteHeader(404)
 }
+
+func chain(handler Handler, middleware []Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func methodNotAllowedHandler(out http.ResponseWriter, req *Request) {
+	out.WriteHeader(405)
+}
+
+// Discards the body of GET handlers serving a HEAD request
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
@@ -1,9 +1,19 @@
 package router
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/karlseguin/expect"
 	"github.com/karlseguin/expect/build"
@@ -37,6 +47,81 @@ func (_ RouterTests) NotFoundWithCustomHandler() {
 	Expect(res.Body.Bytes()).To.Equal([]byte("not found"))
 }
 
+func (_ RouterTests) MethodNotAllowed() {
+	router := New(Configure())
+	router.Get("/users/:id", testHandler("get"))
+	router.Delete("/users/:id", testHandler("delete"))
+	router.Post("/users", testHandler("post"))
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method("PUT").Path("/users/9001").Request)
+	Expect(res.Code).To.Equal(405)
+	Expect(res.Header().Get("Allow")).To.Equal("DELETE, GET")
+
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method("GET").Path("/users").Request)
+	Expect(res.Code).To.Equal(405)
+	Expect(res.Header().Get("Allow")).To.Equal("POST")
+
+	assertRouterNotFound(router, "PUT", "/users/9001/likes")
+}
+
+func (_ RouterTests) MethodNotAllowedWithCustomHandler() {
+	router := New(Configure())
+	router.Get("/users", testHandler("get"))
+	router.MethodNotAllowed(func(out http.ResponseWriter, req *Request) {
+		out.WriteHeader(405)
+		out.Write([]byte("allowed: " + out.Header().Get("Allow")))
+	})
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method("POST").Path("/users").Request)
+	Expect(res.Code).To.Equal(405)
+	Expect(res.Body.String()).To.Equal("allowed: GET")
+}
+
+func (_ RouterTests) AutoHead() {
+	router := New(Configure().AutoMethods())
+	router.Get("/users/:id", testHandler("get"))
+	router.Head("/users", testHandler("head"))
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method("HEAD").Path("/users/9001").Request)
+	Expect(res.Code).To.Equal(200)
+	Expect(res.Body.Len()).To.Equal(0)
+
+	assertRouter(router, "HEAD", "/users", "head")
+	assertRouterNotFound(router, "HEAD", "/users/9001/likes")
+}
+
+func (_ RouterTests) AutoHeadIsOptIn() {
+	router := New(Configure())
+	router.Get("/users", testHandler("get"))
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method("HEAD").Path("/users").Request)
+	Expect(res.Code).To.Equal(405)
+	Expect(res.Header().Get("Allow")).To.Equal("GET")
+}
+
+func (_ RouterTests) AutoOptions() {
+	router := New(Configure().AutoMethods())
+	router.Get("/users/:id", testHandler("get"))
+	router.Delete("/users/:id", testHandler("delete"))
+	router.Options("/admin", testHandler("options"))
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method("OPTIONS").Path("/users/9001").Request)
+	Expect(res.Code).To.Equal(204)
+	Expect(res.Header().Get("Allow")).To.Equal("DELETE, GET, HEAD, OPTIONS")
+
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method("PUT").Path("/users/9001").Request)
+	Expect(res.Code).To.Equal(405)
+	Expect(res.Header().Get("Allow")).To.Equal("DELETE, GET, HEAD, OPTIONS")
+
+	assertRouter(router, "OPTIONS", "/admin", "options")
+	assertRouterNotFound(router, "OPTIONS", "/users")
+}
+
 func (_ RouterTests) DefaultRoute() {
 	assertRouting("/", "/")
 }
@@ -89,6 +174,752 @@ func (_ RouterTests) SimpleRoute() {
 	assertNotFound("/users", "GET", "/users/323")
 }
 
+func (_ RouterTests) CleansPaths() {
+	router := New(Configure())
+	router.Get("/users/:id/likes", testHandler("likes"))
+	assertRouter(router, "GET", "//users//9001/likes", "likes")
+	assertRouter(router, "GET", "/users/./9001/likes/", "likes")
+	assertRouter(router, "GET", "/admin/../users/9001/likes", "likes")
+	assertRouterNotFound(router, "GET", "/users/9001/../likes")
+}
+
+func (_ RouterTests) StrictPaths() {
+	router := New(Configure().PathPolicy(PathStrict))
+	router.Get("/users", testHandler("users"))
+	router.Get("/users/:id/", testHandler("user"))
+	router.Get("/admin/*", testHandler("admin"))
+
+	assertRouter(router, "GET", "/users", "users")
+	assertRouter(router, "GET", "/users/9001/", "user")
+	assertRouter(router, "GET", "/admin/", "admin")
+	assertRouter(router, "GET", "/admin/a/", "admin")
+	assertRouterNotFound(router, "GET", "/users/")
+	assertRouterNotFound(router, "GET", "/users/9001")
+	assertRouterNotFound(router, "GET", "//users")
+	assertRouterNotFound(router, "POST", "/users/")
+}
+
+func (_ RouterTests) RedirectPaths() {
+	router := New(Configure().PathPolicy(PathRedirect))
+	router.Get("/users", testHandler("users"))
+	router.Put("/users/:id/", testHandler("user"))
+
+	assertRouter(router, "GET", "/users", "users")
+	assertRouter(router, "PUT", "/users/9001/", "user")
+	assertRedirect(router, "GET", "/users/", 301, "/users")
+	assertRedirect(router, "GET", "/a/..//users?id=1", 301, "/users?id=1")
+	assertRedirect(router, "PUT", "/users//9001", 308, "/users/9001/")
+	assertRouterNotFound(router, "GET", "/other/")
+}
+
+func (_ RouterTests) RedirectsToAnEscapedLocation() {
+	router := New(Configure().PathPolicy(PathRedirect))
+	router.Get("/:site", testHandler("site"))
+	router.Get("/files/:name", testHandler("file"))
+
+	assertRedirect(router, "GET", "/files/a%3Fb%23c%20d/?x=1", 301, "/files/a%3Fb%23c%20d?x=1")
+	assertRedirect(router, "GET", "/%5Cevil.com/", 301, "/%5Cevil.com")
+	assertRedirect(router, "GET", "/a/..//%5Cevil.com/", 301, "/%5Cevil.com")
+}
+
+func (_ RouterTests) DispatchDoesNotAllocate() {
+	router := New(Configure())
+	router.Get("/users/list", noopHandler)
+	router.Get("/users/:userId/likes/:id", noopHandler)
+	out := newDiscardWriter()
+	for _, path := range []string{"/users/list", "/users/499/likes/001a"} {
+		req := build.Request().Path(path).Request
+		allocs := testing.AllocsPerRun(100, func() {
+			router.ServeHTTP(out, req)
+		})
+		Expect(allocs).To.Equal(float64(0)).Message("path: %s", path)
+	}
+}
+
+func (_ RouterTests) ParsesTheQueryOnFirstUse() {
+	var ids []string
+	router := New(Configure())
+	router.Get("/v1/users", func(out http.ResponseWriter, req *Request) {
+		Expect(req.query == nil).To.Equal(true)
+		ids = req.MQuery("id")
+		Expect(req.Query("name")).To.Equal("leto")
+	})
+	router.ServeHTTP(httptest.NewRecorder(), build.Request().URLString("/v1/users?id=1&id=2&name=leto").Request)
+	Expect(ids).To.Equal([]string{"1", "2"})
+}
+
+func (_ RouterTests) RemoveRoute() {
+	router := New(Configure())
+	router.Get("/users/:id", testParamHandler("id"))
+	router.Post("/users/:id", testHandler("update"))
+	router.Get("/users/:id/likes", testHandler("likes"))
+	router.AddNamed("reports", "GET", "/reports/:year?", testHandler("reports"))
+	router.Get("/files/*path", testParamHandler("path"))
+
+	Expect(router.Remove("GET", "/users/:id")).To.Equal(true)
+	Expect(router.Remove("GET", "/users/:id")).To.Equal(false)
+	Expect(router.Remove("GET", "/users/:other")).To.Equal(false)
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/users/9001").Request)
+	Expect(res.Code).To.Equal(405)
+	assertRouter(router, "POST", "/users/9001", "update")
+	assertRouter(router, "GET", "/users/9001/likes", "likes")
+
+	Expect(router.Remove("GET", "/reports/:year?")).To.Equal(true)
+	assertRouterNotFound(router, "GET", "/reports")
+	assertRouterNotFound(router, "GET", "/reports/2015")
+	_, err := router.URL("reports")
+	Expect(err.Error()).To.Equal(`router: unknown route "reports"`)
+
+	Expect(router.Remove("ALL", "/files/*path")).To.Equal(true)
+	assertRouterNotFound(router, "GET", "/files/a.txt")
+	router.Get("/users/:id", testHandler("again"))
+	assertRouter(router, "GET", "/users/9001", "again")
+}
+
+func (_ RouterTests) ReplaceRoutes() {
+	router := New(Configure())
+	router.Get("/users", testHandler("users"))
+	router.Group("/admin").NotFound(testHandler("admin-404"))
+	router.Replace(func(b *Builder) {
+		b.Get("/tenants/:id", testParamHandler("id"))
+		api := b.Group("/api")
+		api.Get("/status", testHandler("status"))
+		api.NotFound(testHandler("api-404"))
+	})
+	assertRouterNotFound(router, "GET", "/users")
+	assertRouterNotFound(router, "GET", "/admin/users")
+	assertRouter(router, "GET", "/tenants/3", "3")
+	assertRouter(router, "GET", "/api/status", "status")
+	assertRouter(router, "GET", "/api/other", "api-404")
+}
+
+func (_ RouterTests) ReplaceKeepsRoutesWhenItPanics() {
+	router := New(Configure())
+	router.Get("/users", testHandler("users"))
+	func() {
+		defer func() { recover() }()
+		router.Replace(func(b *Builder) {
+			b.Get("/tenants", testHandler("tenants"))
+			b.Get("/bad/:id(", testHandler("bad"))
+		})
+	}()
+	assertRouter(router, "GET", "/users", "users")
+	assertRouterNotFound(router, "GET", "/tenants")
+	router.Get("/other", testHandler("other"))
+	assertRouter(router, "GET", "/other", "other")
+}
+
+func (_ RouterTests) RegistersWhileServing() {
+	router := New(Configure())
+	router.Get("/users/:id", testParamHandler("id"))
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 200; i++ {
+			path := "/items/" + strings.Repeat("a", i%20+1)
+			router.Get(path, testHandler("item"))
+			router.Remove("GET", path)
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			assertRouter(router, "GET", "/users/9001", "9001")
+		}
+	}
+}
+
+func (_ RouterTests) ConfiguresWhileServing() {
+	router := New(Configure())
+	router.Get("/users/:id", testParamHandler("id"))
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 50; i++ {
+			name := strconv.Itoa(i)
+			router.Constraint("c"+name, func(value string) bool { return value == name })
+			router.Host(name+".example.com").Get("/users/:id(c"+name+")", testHandler(name))
+			router.Use(func(next Handler) Handler { return next })
+			router.NotFound(notFoundHandler)
+			router.MethodNotAllowed(methodNotAllowedHandler)
+			router.PanicHandler(defaultPanicHandler)
+		}
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			assertHostRouter(router, "www.example.com", "/users/9001", 200, "9001")
+		}
+	}
+	assertHostRouter(router, "10.example.com", "/users/10", 200, "10")
+	assertHostRouter(router, "10.example.com", "/users/11", 404, "")
+}
+
+func (_ RouterTests) WalkRoutes() {
+	router := New(Configure())
+	router.Get("/", testHandler("root"))
+	router.AddNamed("user", "GET", "/users/:id(uint):.json", testHandler("user"))
+	router.Get("/range/:from(int)-:to", testHandler("range"))
+	router.Get("/reports/:year/:month=1", testHandler("reports"))
+	router.Delete("/users/ad*rest", testHandler("admin"))
+	router.Post("/files/*", testHandler("files"))
+	router.Get("/temp", testHandler("temp"))
+	router.Remove("GET", "/temp")
+
+	var routes []RouteInfo
+	err := router.Walk(func(info RouteInfo) error {
+		routes = append(routes, info)
+		return nil
+	})
+	Expect(err).To.Equal(nil)
+	Expect(routes).To.Equal([]RouteInfo{
+		{Method: "GET", Pattern: "/", Name: "GET:/"},
+		{Method: "GET", Pattern: "/users/:id(uint):.json", Name: "user", Params: []ParamInfo{
+			{Name: "id", Constraint: "uint", Postfix: ".json"},
+		}},
+		{Method: "GET", Pattern: "/range/:from(int)-:to", Name: "GET:/range/:from(int)-:to", Params: []ParamInfo{
+			{Name: "from", Constraint: "int", Postfix: "-"},
+			{Name: "to"},
+		}},
+		{Method: "GET", Pattern: "/reports/:year/:month=1", Name: "GET:/reports/:year/:month=1", Params: []ParamInfo{
+			{Name: "year"},
+			{Name: "month", Optional: true, Default: "1"},
+		}},
+		{Method: "DELETE", Pattern: "/users/ad*rest", Name: "DELETE:/users/ad*rest", Prefix: true, Params: []ParamInfo{
+			{Name: "rest", CatchAll: true},
+		}},
+		{Method: "POST", Pattern: "/files/*", Name: "POST:/files/*", Glob: true},
+	})
+}
+
+func (_ RouterTests) WalkStopsOnError() {
+	router := New(Configure())
+	router.Get("/a", testHandler("a"))
+	router.Get("/b", testHandler("b"))
+	count := 0
+	err := router.Walk(func(info RouteInfo) error {
+		count++
+		return ErrBadPattern
+	})
+	Expect(err).To.Equal(ErrBadPattern)
+	Expect(count).To.Equal(1)
+}
+
+func (_ RouterTests) DumpText() {
+	router := New(Configure())
+	router.Get("/", testHandler("root"))
+	router.Get("/users/list", testHandler("list"))
+	router.AddNamed("user", "GET", "/users/:id(uint):.json", testHandler("user"))
+	router.Get("/users/:id/likes", testHandler("likes"))
+	router.Get("/users/ad*rest", testHandler("admin"))
+	router.Get("/use", testHandler("use"))
+	router.Delete("/files/*path", testHandler("files"))
+
+	var out strings.Builder
+	Expect(router.Dump(&out, DumpText)).To.Equal(nil)
+	Expect(out.String()).To.Equal(`DELETE
+  files/
+    *path (glob) => DELETE:/files/*path
+GET
+  / => GET:/
+  use
+    rs/
+      list/ => GET:/users/list
+      :id(uint):.json/ => user
+      :id/
+        likes/ => GET:/users/:id/likes
+      ad*rest (prefix) => GET:/users/ad*rest
+    / => GET:/use
+`)
+}
+
+func (_ RouterTests) DumpJSONAndDOT() {
+	router := New(Configure())
+	router.Get("/users/:id(^\\d+$)", testHandler("user"))
+
+	var out strings.Builder
+	Expect(router.Dump(&out, DumpJSON)).To.Equal(nil)
+	Expect(out.String()).To.Contain(`"label": ":id(^\\d+$)/",`)
+	Expect(out.String()).To.Contain(`"pattern": "/users/:id(^\\d+$)"`)
+
+	out.Reset()
+	Expect(router.Dump(&out, DumpDOT)).To.Equal(nil)
+	Expect(out.String()).To.Equal(`digraph routes {
+  node [shape=box];
+  n0 [label="GET"];
+  n1 [label="users/"];
+  n0 -> n1;
+  n2 [label=":id(^\\d+$)/\nGET:/users/:id(^\\d+$)", style=bold];
+  n1 -> n2;
+}
+`)
+}
+
+func (_ RouterTests) OpenAPI() {
+	router := New(Configure())
+	router.Define(Definition{
+		Name:    "user",
+		Method:  "GET",
+		Path:    "/users/:id(^\\d+$):.json",
+		Handler: testHandler("user"),
+		Doc: &Doc{
+			Summary:   "Get a user",
+			Tags:      []string{"users"},
+			Params:    map[string]string{"id": "the user's id"},
+			Responses: map[int]interface{}{200: map[string]string{"type": "object"}, 404: nil},
+		},
+	})
+	router.Group("/v1").Define(Definition{
+		Method:  "POST",
+		Path:    "/users",
+		Handler: testHandler("create"),
+		Doc:     &Doc{Request: map[string]string{"type": "object"}},
+	})
+	router.Get("/reports/:year(uint)/:month?", testHandler("reports"))
+	router.Get("/files/*path", testHandler("files"))
+	router.Get("/static/*", testHandler("static"))
+	router.All("/power", testHandler("power"))
+
+	data, err := router.OpenAPI("Users", "1.0")
+	Expect(err).To.Equal(nil)
+	var doc map[string]interface{}
+	Expect(json.Unmarshal(data, &doc)).To.Equal(nil)
+	Expect(doc["openapi"]).To.Equal("3.1.0")
+	Expect(doc["info"]).To.Equal(map[string]interface{}{"title": "Users", "version": "1.0"})
+
+	paths := doc["paths"].(map[string]interface{})
+	var names []string
+	for path := range paths {
+		names = append(names, path)
+	}
+	sort.Strings(names)
+	Expect(names).To.Equal([]string{"/power", "/reports/{year}", "/reports/{year}/{month}", "/users/{id}.json", "/v1/users"})
+
+	Expect(paths["/users/{id}.json"]).To.Equal(map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "user",
+			"summary":     "Get a user",
+			"tags":        []interface{}{"users"},
+			"parameters": []interface{}{map[string]interface{}{
+				"name":        "id",
+				"in":          "path",
+				"description": "the user's id",
+				"required":    true,
+				"schema":      map[string]interface{}{"type": "string", "pattern": "^\\d+$"},
+			}},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{"description": "OK", "content": map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"type": "object"}}}},
+				"404": map[string]interface{}{"description": "Not Found"},
+			},
+		},
+	})
+	Expect(paths["/v1/users"]).To.Equal(map[string]interface{}{
+		"post": map[string]interface{}{
+			"operationId": "POST:/v1/users",
+			"requestBody": map[string]interface{}{"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"type": "object"}}}},
+		},
+	})
+	year := paths["/reports/{year}/{month}"].(map[string]interface{})["get"].(map[string]interface{})["parameters"].([]interface{})[0]
+	Expect(year.(map[string]interface{})["schema"]).To.Equal(map[string]interface{}{"type": "integer", "minimum": float64(0)})
+	Expect(len(paths["/power"].(map[string]interface{}))).To.Equal(7)
+}
+
+func (_ RouterTests) OpenAPICollisions() {
+	router := New(Configure())
+	router.Get("/a/:v(int)", testHandler("int"))
+	router.Get("/a/:v(hex)", testHandler("hex"))
+	_, err := router.OpenAPI("Users", "1.0")
+	Expect(err.Error()).To.Equal("router: GET /a/:v(int) and GET /a/:v(hex) are both GET /a/{v} in OpenAPI")
+
+	router = New(Configure())
+	router.Get("/users/:id", testHandler("get"))
+	router.Delete("/users/:name", testHandler("delete"))
+	_, err = router.OpenAPI("Users", "1.0")
+	Expect(err.Error()).To.Equal("router: OpenAPI paths /users/{id} and /users/{name} only differ by their parameter names")
+
+	router = New(Configure())
+	router.Get("/users/:id", testHandler("get"))
+	router.Delete("/users/:id", testHandler("delete"))
+	router.Get("/users/:id/likes/:like", testHandler("like"))
+	_, err = router.OpenAPI("Users", "1.0")
+	Expect(err).To.Equal(nil)
+}
+
+func (_ RouterTests) RouteMetadata() {
+	router := New(Configure())
+	router.Use(func(next Handler) Handler {
+		return func(out http.ResponseWriter, req *Request) {
+			if scopes, _ := req.Route().Meta("scopes").([]string); len(scopes) != 0 {
+				out.Write([]byte(strings.Join(scopes, ",") + "-"))
+			}
+			next(out, req)
+		}
+	})
+	meta := map[string]interface{}{"scopes": []string{"admin", "users"}, "team": "identity"}
+	router.Define(Definition{Name: "user", Method: "DELETE", Path: "/users/:id", Meta: meta, Handler: func(out http.ResponseWriter, req *Request) {
+		out.Write([]byte(req.Route().Meta("team").(string)))
+	}})
+	meta["team"] = "changed"
+	router.Get("/users", func(out http.ResponseWriter, req *Request) {
+		Expect(req.Route().Name).To.Equal("GET:/users")
+		Expect(req.Route().Meta("team")).To.Equal(nil)
+		out.Write([]byte("users"))
+	})
+	router.NotFound(func(out http.ResponseWriter, req *Request) {
+		Expect(req.Route() == nil).To.Equal(true)
+		Expect(req.Route().Meta("team")).To.Equal(nil)
+		out.WriteHeader(404)
+	})
+
+	assertRouter(router, "DELETE", "/users/9001", "admin,users-identity")
+	assertRouter(router, "GET", "/users", "users")
+	assertRouterNotFound(router, "GET", "/other")
+}
+
+func (_ RouterTests) MatchedRoute() {
+	var route Route
+	capture := func(out http.ResponseWriter, req *Request) {
+		route = *req.Route()
+		out.Write([]byte(route.Pattern))
+	}
+	router := New(Configure().AutoMethods())
+	router.AddNamed("user", "GET", "/users/:id(uint)", capture)
+	router.All("/files/*path", capture)
+	router.Get("/users/ad*", capture)
+	router.Get("/reports/:year?", capture)
+	router.NotFound(func(out http.ResponseWriter, req *Request) {
+		Expect(req.Route() == nil).To.Equal(true)
+		out.WriteHeader(404)
+	})
+	router.MethodNotAllowed(func(out http.ResponseWriter, req *Request) {
+		Expect(req.Route() == nil).To.Equal(true)
+		out.WriteHeader(405)
+	})
+
+	assertRouter(router, "GET", "/users/9001", "/users/:id(uint)")
+	Expect(route).To.Equal(Route{Name: "user", Method: "GET", Pattern: "/users/:id(uint)"})
+
+	assertRouter(router, "PUT", "/files/a/b.txt", "/files/*path")
+	Expect(route).To.Equal(Route{Name: "PUT:/files/*path", Method: "PUT", Pattern: "/files/*path", Glob: true})
+
+	assertRouter(router, "GET", "/users/admin", "/users/ad*")
+	Expect(route.Prefix).To.Equal(true)
+
+	assertRouter(router, "GET", "/reports", "/reports/:year?")
+	Expect(route.Pattern).To.Equal("/reports/:year?")
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method("HEAD").Path("/users/9001").Request)
+	Expect(route.Method).To.Equal("GET")
+
+	assertRouterNotFound(router, "GET", "/other")
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method("POST").Path("/users/9001").Request)
+	Expect(res.Code).To.Equal(405)
+}
+
+func (_ RouterTests) Metrics() {
+	metrics := NewMetrics(0.5, 0.1)
+	router := New(Configure())
+	router.Use(metrics.Middleware)
+	router.AddNamed("user", "GET", "/users/:id", func(out http.ResponseWriter, req *Request) {
+		if req.Param("id") == "0" {
+			out.WriteHeader(400)
+		}
+		out.Write([]byte("user"))
+	})
+	router.AddNamed("user", "DELETE", "/users/:id", testHandler("deleted"))
+	router.Get("/metrics", metrics.Handler)
+
+	assertRouter(router, "GET", "/users/1", "user")
+	assertRouter(router, "GET", "/users/2", "user")
+	assertRouter(router, "DELETE", "/users/2", "deleted")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/users/0").Request)
+	Expect(res.Code).To.Equal(400)
+	assertRouterNotFound(router, "GET", "/other")
+
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/metrics").Request)
+	Expect(res.Header().Get("Content-Type")).To.Equal("text/plain; version=0.0.4; charset=utf-8")
+	body := res.Body.String()
+	Expect(body).To.Contain("# TYPE router_requests_total counter\n")
+	Expect(body).To.Contain(`router_requests_total{route="user",method="GET",code="2xx"} 2` + "\n")
+	Expect(body).To.Contain(`router_requests_total{route="user",method="GET",code="4xx"} 1` + "\n")
+	Expect(body).To.Contain(`router_requests_total{route="user",method="DELETE",code="2xx"} 1` + "\n")
+	Expect(body).To.Contain(`router_requests_total{route="unmatched",method="GET",code="4xx"} 1` + "\n")
+	Expect(body).To.Contain("# TYPE router_request_duration_seconds histogram\n")
+	Expect(body).To.Contain(`router_request_duration_seconds_bucket{route="user",method="GET",le="0.1"} 3` + "\n")
+	Expect(body).To.Contain(`router_request_duration_seconds_bucket{route="user",method="GET",le="0.5"} 3` + "\n")
+	Expect(body).To.Contain(`router_request_duration_seconds_bucket{route="user",method="GET",le="+Inf"} 3` + "\n")
+	Expect(body).To.Contain(`router_request_duration_seconds_count{route="user",method="GET"} 3` + "\n")
+	Expect(body).To.Contain(`router_requests_in_flight{route="user",method="GET"} 0` + "\n")
+	Expect(body).To.Contain(`router_requests_in_flight{route="GET:/metrics",method="GET"} 1` + "\n")
+	Expect(strings.Index(body, `route="user",method="DELETE"`) < strings.Index(body, `route="user",method="GET"`)).To.Equal(true)
+}
+
+func (_ RouterTests) MetricsBoundUnmatchedMethods() {
+	metrics := NewMetrics()
+	router := New(Configure())
+	router.Use(metrics.Middleware)
+	router.Get("/panic", func(out http.ResponseWriter, req *Request) {
+		panic("boom")
+	})
+	for _, method := range []string{"AAA", "BBB", "GET"} {
+		router.ServeHTTP(httptest.NewRecorder(), build.Request().Method(method).Path("/other").Request)
+	}
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/panic").Request)
+	Expect(res.Code).To.Equal(500)
+
+	var out strings.Builder
+	metrics.WriteTo(&out)
+	body := out.String()
+	Expect(body).To.Contain(`router_requests_total{route="unmatched",method="OTHER",code="4xx"} 2` + "\n")
+	Expect(body).To.Contain(`router_requests_total{route="unmatched",method="GET",code="4xx"} 1` + "\n")
+	Expect(body).To.Contain(`router_requests_total{route="GET:/panic",method="GET",code="5xx"} 1` + "\n")
+	Expect(body).To.Contain(`router_requests_in_flight{route="GET:/panic",method="GET"} 0` + "\n")
+	Expect(strings.Contains(body, "AAA")).To.Equal(false)
+}
+
+func (_ RouterTests) AccessLogFormats() {
+	expected := map[LogFormat]string{
+		LogCommon:   `10.0.0.1 - leto [02/Jan/2015:03:04:05 +0000] "GET /users/9001?full=1 HTTP/1.1" 200 4` + "\n",
+		LogCombined: `10.0.0.1 - leto [02/Jan/2015:03:04:05 +0000] "GET /users/9001?full=1 HTTP/1.1" 200 4 "-" "spice \"harvester\""` + "\n",
+		LogJSON:     `{"time":"2015-01-02T03:04:05Z","method":"GET","route":"user","path":"/users/9001?full=1","status":200,"bytes":4,"duration_ms":250,"remote_ip":"10.0.0.1","user_agent":"spice \"harvester\""}` + "\n",
+	}
+	for format, line := range expected {
+		var out strings.Builder
+		log := NewAccessLog(&out, format)
+		start := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+		calls := 0
+		log.now = func() time.Time {
+			calls++
+			if calls%2 == 0 {
+				return start.Add(250 * time.Millisecond)
+			}
+			return start
+		}
+		router := New(Configure())
+		router.Use(log.Middleware)
+		router.AddNamed("user", "GET", "/users/:id", testHandler("user"))
+
+		req := httptest.NewRequest("GET", "/users/9001?full=1", nil)
+		req.RemoteAddr = "10.0.0.1:4000"
+		req.SetBasicAuth("leto", "ghanima")
+		req.Header.Set("User-Agent", `spice "harvester"`)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(out.String()).To.Equal(line)
+	}
+}
+
+func (_ RouterTests) AccessLogReusesTheRequestsRecorder() {
+	var out strings.Builder
+	log := NewAccessLog(&out, LogCommon)
+	res := httptest.NewRecorder()
+	router := New(Configure())
+	router.Use(log.Middleware)
+	router.Get("/users", func(w http.ResponseWriter, req *Request) {
+		Expect(w.(*recorder).ResponseWriter == res).To.Equal(true)
+		w.Write([]byte("users"))
+	})
+	router.ServeHTTP(res, build.Request().Path("/users").Request)
+	Expect(out.String()).To.Contain(`" 200 5`)
+
+	out.Reset()
+	log.Middleware(testHandler("direct"))(httptest.NewRecorder(), NewRequest(build.Request().Path("/direct").Request, EmptyParams))
+	Expect(out.String()).To.Contain(`" 200 6`)
+}
+
+func (_ RouterTests) AccessLogLogsPanics() {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	var out strings.Builder
+	accessLog := NewAccessLog(&out, LogCommon)
+	metrics := NewMetrics()
+	router := New(Configure())
+	panicking := func(next Handler) Handler {
+		return func(out http.ResponseWriter, req *Request) {
+			panic("boom")
+		}
+	}
+	router.Use(accessLog.Middleware, metrics.Middleware, panicking)
+	router.Get("/users", testHandler("users"))
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/users").Request)
+	Expect(res.Code).To.Equal(500)
+	Expect(out.String()).To.Contain(`"GET /users HTTP/1.1" 500 -`)
+}
+
+func (_ RouterTests) AccessLogSampling() {
+	var out strings.Builder
+	log := NewAccessLog(&out, LogCommon).Sample("GET:/health", 0).Sample("unmatched", 1)
+	router := New(Configure())
+	router.Use(log.Middleware)
+	router.Get("/health", testHandler("ok"))
+	router.Get("/users", testHandler("users"))
+
+	assertRouter(router, "GET", "/health", "ok")
+	Expect(out.Len()).To.Equal(0)
+	assertRouter(router, "GET", "/users", "users")
+	Expect(out.String()).To.Contain(`"GET /users `)
+	Expect(out.String()).To.Contain(`" 200 5`)
+	out.Reset()
+	assertRouterNotFound(router, "GET", "/other")
+	Expect(out.String()).To.Contain(`"GET /other `)
+	Expect(out.String()).To.Contain(`" 404 -`)
+}
+
+func (_ RouterTests) RecoversPanics() {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	router := New(Configure())
+	router.Get("/fail", func(out http.ResponseWriter, req *Request) {
+		panic("boom")
+	})
+	router.Get("/partial", func(out http.ResponseWriter, req *Request) {
+		out.WriteHeader(201)
+		out.Write([]byte("partial"))
+		panic("boom")
+	})
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/fail").Request)
+	Expect(res.Code).To.Equal(500)
+	Expect(logged.String()).To.Contain("router: panic serving GET /fail: boom")
+	Expect(logged.String()).To.Contain("goroutine")
+
+	out := newDiscardWriter()
+	router.ServeHTTP(out, build.Request().Path("/partial").Request)
+	Expect(out.headers).To.Equal(1)
+}
+
+func (_ RouterTests) HostRoutersUseTheCurrentPanicHandler() {
+	router := New(Configure())
+	admin := router.Host("admin.example.com")
+	admin.Get("/", func(out http.ResponseWriter, req *Request) {
+		panic("admin")
+	})
+	api := router.Host("api.example.com")
+	api.Get("/", func(out http.ResponseWriter, req *Request) {
+		panic("api")
+	})
+	router.PanicHandler(func(out http.ResponseWriter, req *Request, recovered interface{}) {
+		out.WriteHeader(503)
+	})
+	api.PanicHandler(func(out http.ResponseWriter, req *Request, recovered interface{}) {
+		out.WriteHeader(502)
+	})
+	assertHostRouter(router, "admin.example.com", "/", 503, "")
+	assertHostRouter(router, "api.example.com", "/", 502, "")
+}
+
+func (_ RouterTests) PanicInWriteHeaderLeavesTheResponseUnwritten() {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	router := New(Configure())
+	router.Get("/invalid", func(out http.ResponseWriter, req *Request) {
+		out.WriteHeader(1000)
+	})
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/invalid").Request)
+	Expect(res.Code).To.Equal(500)
+}
+
+func (_ RouterTests) InformationalResponsesDontCountAsWritten() {
+	router := New(Configure())
+	router.Get("/hints", func(out http.ResponseWriter, req *Request) {
+		out.WriteHeader(103)
+		Expect(req.Written()).To.Equal(false)
+		out.Write([]byte("hints"))
+		Expect(req.Written()).To.Equal(true)
+	})
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/hints").Request)
+	Expect(res.Body.String()).To.Equal("hints")
+}
+
+func (_ RouterTests) RecorderKeepsReaderFrom() {
+	size := 0
+	router := New(Configure())
+	router.Get("/copy", func(out http.ResponseWriter, req *Request) {
+		// hide strings.Reader's WriteTo, which io.Copy would prefer
+		io.Copy(out, struct{ io.Reader }{strings.NewReader("copied")})
+		size = req.recorder.size
+	})
+
+	out := &readerFromWriter{discardWriter: newDiscardWriter()}
+	router.ServeHTTP(out, build.Request().Path("/copy").Request)
+	Expect(out.read).To.Equal(int64(6))
+	Expect(size).To.Equal(6)
+
+	size = 0
+	router.ServeHTTP(newDiscardWriter(), build.Request().Path("/copy").Request)
+	Expect(size).To.Equal(6)
+}
+
+func (_ RouterTests) CustomPanicHandler() {
+	router := New(Configure())
+	router.PanicHandler(func(out http.ResponseWriter, req *Request, recovered interface{}) {
+		out.WriteHeader(503)
+		out.Write([]byte(fmt.Sprint("recovered-", recovered)))
+	})
+	router.Use(testMiddleware("outer"))
+	router.Get("/fail", func(out http.ResponseWriter, req *Request) {
+		panic("handler")
+	})
+	router.Get("/middleware", testHandler("ok"), func(next Handler) Handler {
+		return func(out http.ResponseWriter, req *Request) {
+			panic("middleware")
+		}
+	})
+	router.NotFound(func(out http.ResponseWriter, req *Request) {
+		panic("not found")
+	})
+
+	for path, body := range map[string]string{"/fail": "outer-recovered-handler", "/middleware": "outer-recovered-middleware", "/other": "outer-recovered-not found"} {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, build.Request().Path(path).Request)
+		Expect(res.Code).To.Equal(200).Message("path: %s", path)
+		Expect(res.Body.String()).To.Equal(body).Message("path: %s", path)
+	}
+
+	router = New(Configure())
+	router.Use(func(next Handler) Handler {
+		return func(out http.ResponseWriter, req *Request) {
+			panic("router middleware")
+		}
+	})
+	router.PanicHandler(func(out http.ResponseWriter, req *Request, recovered interface{}) {
+		out.WriteHeader(503)
+	})
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/").Request)
+	Expect(res.Code).To.Equal(503)
+}
+
+func (_ RouterTests) AbortHandlerIsNotRecovered() {
+	router := New(Configure())
+	router.Get("/abort", func(out http.ResponseWriter, req *Request) {
+		panic(http.ErrAbortHandler)
+	})
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+		router.ServeHTTP(httptest.NewRecorder(), build.Request().Path("/abort").Request)
+	}()
+	Expect(recovered).To.Equal(http.ErrAbortHandler)
+}
+
 func (_ RouterTests) ExposesQueryParameters() {
 	id := ""
 	router := New(Configure())
@@ -125,6 +956,117 @@ func (_ RouterTests) RouteWithParameterAndExtension() {
 	assertRouting("/users/:id:.json/:other", "/users/3233.json/xx", "id", "3233", "other", "xx")
 }
 
+func (_ RouterTests) RouteWithParametersInOneSegment() {
+	router := New(Configure())
+	router.Get("/range/:from-:to", testParamHandler("from", "to"))
+	router.Get("/v:version/items", testParamHandler("version"))
+	router.Get("/img/:name.:ext", testParamHandler("name", "ext"))
+	router.Get("/@:user", testParamHandler("user"))
+	router.Get("/d/:y(uint)-:m(uint)-:d(uint).:format", testParamHandler("y", "m", "d", "format"))
+	router.Get("/users/:id:.json", testParamHandler("id"))
+
+	assertRouter(router, "GET", "/range/10-20", "10 20")
+	assertRouter(router, "GET", "/range/a-b-c", "a-b c")
+	assertRouter(router, "GET", "/v2/items", "2")
+	assertRouter(router, "GET", "/img/leto.png", "leto png")
+	assertRouter(router, "GET", "/img/leto.tar.gz", "leto.tar gz")
+	assertRouter(router, "GET", "/@leto", "leto")
+	assertRouter(router, "GET", "/d/2015-12-31.json", "2015 12 31 json")
+	assertRouter(router, "GET", "/users/9001.json", "9001")
+	assertRouterNotFound(router, "GET", "/range/10")
+	assertRouterNotFound(router, "GET", "/range/-20")
+	assertRouterNotFound(router, "GET", "/v/items")
+	assertRouterNotFound(router, "GET", "/img/leto")
+	assertRouterNotFound(router, "GET", "/d/2015-12-3a.json")
+}
+
+func (_ RouterTests) SingleParameterNamesKeepTheirPunctuation() {
+	router := New(Configure())
+	router.AddNamed("user", "GET", "/users/:user-id", testParamHandler("user-id"))
+	router.Get("/docs/:doc.name(^[a-z]+$):.json", testParamHandler("doc.name"))
+
+	assertRouter(router, "GET", "/users/9001", "9001")
+	assertRouter(router, "GET", "/docs/leto.json", "leto")
+	assertRouterNotFound(router, "GET", "/docs/9001.json")
+	assertURL(router, "/users/9001", "user", "user-id", "9001")
+}
+
+func (_ RouterTests) ParametersInOneSegmentURL() {
+	router := New(Configure())
+	router.AddNamed("range", "GET", "/range/:from(int)-:to(int)", testHandler("range"))
+	router.AddNamed("item", "GET", "/v:version/items/@:user/:name.:ext", testHandler("item"))
+	assertURL(router, "/range/10-20", "range", "from", "10", "to", "20")
+	assertURL(router, "/v2/items/@leto/a.png", "item", "version", "2", "user", "leto", "name", "a", "ext", "png")
+	_, err := router.URL("range", "from", "a", "to", "20")
+	Expect(err.Error()).To.Equal(`router: route "range" parameter "from" value "a" does not match int`)
+}
+
+func (_ RouterTests) ParametersInOneSegmentMustBeSeparated() {
+	router := New(Configure())
+	assertRouteError(router.TryAdd("GET", "/range/:from:to", testHandler("")), ErrBadPattern, "router: GET /range/:from:to: bad pattern, parameters \"from\" and \"to\" must be separated by text")
+	assertRouteError(router.TryAdd("GET", "/range/:from-:from", testHandler("")), ErrDuplicateVariable, "router: GET /range/:from-:from: duplicate variable, from")
+}
+
+func (_ RouterTests) OptionalSegments() {
+	router := New(Configure())
+	router.Get("/reports/:year/:month?/:day(uint)?", testParamHandler("year", "month", "day"))
+	router.Get("/list/:page(int)=1/:size=20", testParamHandler("page", "size"))
+	router.Get("/:lang=en", testParamHandler("lang"))
+
+	assertRouter(router, "GET", "/reports/2015", "2015  ")
+	assertRouter(router, "GET", "/reports/2015/12", "2015 12 ")
+	assertRouter(router, "GET", "/reports/2015/12/31", "2015 12 31")
+	assertRouter(router, "GET", "/list", "1 20")
+	assertRouter(router, "GET", "/list/3", "3 20")
+	assertRouter(router, "GET", "/list/3/50", "3 50")
+	assertRouter(router, "GET", "/", "en")
+	assertRouter(router, "GET", "/fr", "fr")
+	assertRouter(router, "GET", "/reports", "reports")
+	assertRouterNotFound(router, "GET", "/reports/2015/12/a")
+	assertRouterNotFound(router, "GET", "/list/a")
+}
+
+func (_ RouterTests) OptionalSegmentsWithText() {
+	router := New(Configure())
+	router.Get("/items/v:version?", testParamHandler("version"))
+	router.Get("/range/:from-:to?", testParamHandler("from", "to"))
+	router.Get("/users/:user-id=me", testParamHandler("user-id"))
+
+	assertRouter(router, "GET", "/items", "")
+	assertRouter(router, "GET", "/items/v2", "2")
+	assertRouter(router, "GET", "/range", " ")
+	assertRouter(router, "GET", "/range/1-5", "1 5")
+	assertRouter(router, "GET", "/users", "me")
+	assertRouter(router, "GET", "/users/9001", "9001")
+	assertRouterNotFound(router, "GET", "/items/2")
+}
+
+func (_ RouterTests) OptionalSegmentsURL() {
+	router := New(Configure())
+	router.AddNamed("reports", "GET", "/reports/:year/:month?", testHandler("reports"))
+	router.AddNamed("list", "GET", "/list/:page(int)=1", testHandler("list"))
+	assertURL(router, "/reports/2015", "reports", "year", "2015")
+	assertURL(router, "/reports/2015/12", "reports", "year", "2015", "month", "12")
+	assertURL(router, "/list", "list")
+	assertURL(router, "/list/3", "list", "page", "3")
+
+	router.AddNamed("items", "GET", "/items/v:version?", testHandler("items"))
+	router.AddNamed("range", "GET", "/range/:from-:to?", testHandler("range"))
+	router.AddNamed("root", "GET", "/v:version?", testHandler("root"))
+	assertURL(router, "/items", "items")
+	assertURL(router, "/items/v2", "items", "version", "2")
+	assertURL(router, "/range", "range", "from", "1")
+	assertURL(router, "/range/1-5", "range", "from", "1", "to", "5")
+	assertURL(router, "/", "root")
+}
+
+func (_ RouterTests) OptionalSegmentsMustBeLast() {
+	router := New(Configure())
+	assertRouteError(router.TryAdd("GET", "/reports/:year?/summary", testHandler("")), ErrBadPattern, "router: GET /reports/:year?/summary: bad pattern, only the last segments can be optional")
+	assertRouteError(router.TryAdd("GET", "/reports/:year?/:month", testHandler("")), ErrBadPattern, "router: GET /reports/:year?/:month: bad pattern, only the last segments can be optional")
+	assertRouteError(router.TryAdd("GET", "/range/:from-:to=1-5", testHandler("")), ErrBadPattern, "router: GET /range/:from-:to=1-5: bad pattern, only a segment with a single parameter can have a default")
+}
+
 func (_ RouterTests) RouteWithMultipleParameter() {
 	router := New(Configure())
 	router.Get("/users/:id", testHandler("route-1"))
@@ -207,8 +1149,9 @@ func (_ RouterTests) RoutingWithPrefix() {
 	assertRouter(router, "PUT", "/users/b/favorites", "user-favorites")
 	assertRouter(router, "PUT", "/users/b/favorites/323", "user-favorites")
 
-	assertRouter(router, "PUT", "/users/ab", "user-glob-1")
-	assertRouter(router, "PUT", "/users/aBaa", "user-glob-1")
+	assertRouter(router, "PUT", "/users/ab", "user-id")
+	assertRouter(router, "PUT", "/users/aBaa", "user-id")
+	assertRouter(router, "PUT", "/users/ab/other", "user-glob-1")
 	assertRouter(router, "PUT", "/users/ab444/asds", "user-glob-1")
 
 	assertRouterNotFound(router, "PUT", "/user")
@@ -216,6 +1159,170 @@ func (_ RouterTests) RoutingWithPrefix() {
 	assertRouterNotFound(router, "PUT", "/users/233/other")
 }
 
+func (_ RouterTests) RoutingWithNamedConstraint() {
+	router := New(Configure())
+	router.Constraint("even", func(value string) bool {
+		return len(value) > 0 && (value[len(value)-1]-'0')%2 == 0
+	})
+	router.Get("/a/:v(int)", testParamHandler("v"))
+	router.Get("/a/:v(uuid)", testHandler("uuid"))
+	router.Get("/a/:v(date)", testHandler("date"))
+	router.Get("/a/:v(alpha)", testHandler("alpha"))
+	router.Get("/a/:v(hex)", testHandler("hex"))
+	router.Get("/b/:v(uint)", testHandler("uint"))
+	router.Get("/c/:v(even)", testHandler("even"))
+
+	assertRouter(router, "GET", "/a/9001", "9001")
+	assertRouter(router, "GET", "/a/-9001", "-9001")
+	assertRouter(router, "GET", "/a/d9b2d63d-a233-4123-847a-5d1f32e5b7a4", "uuid")
+	assertRouter(router, "GET", "/a/2015-12-31", "date")
+	assertRouter(router, "GET", "/a/leTo", "alpha")
+	assertRouter(router, "GET", "/a/9f", "hex")
+	assertRouter(router, "GET", "/b/9001", "uint")
+	assertRouter(router, "GET", "/c/9002", "even")
+	assertRouterNotFound(router, "GET", "/a/2015-13-31")
+	assertRouterNotFound(router, "GET", "/a/le-to")
+	assertRouterNotFound(router, "GET", "/b/-9001")
+	assertRouterNotFound(router, "GET", "/c/9001")
+}
+
+func (_ RouterTests) NamedConstraints() {
+	for _, valid := range []string{"0", "9001", "-1"} {
+		Expect(isInt(valid)).To.Equal(true).Message(valid)
+	}
+	for _, invalid := range []string{"", "-", "1.0", "a1", "--1"} {
+		Expect(isInt(invalid)).To.Equal(false).Message(invalid)
+	}
+	for _, valid := range []string{"D9B2D63D-A233-4123-847A-5D1F32E5B7A4", "00000000-0000-0000-0000-000000000000"} {
+		Expect(isUUID(valid)).To.Equal(true).Message(valid)
+	}
+	for _, invalid := range []string{"", "d9b2d63d-a233-4123-847a-5d1f32e5b7a", "d9b2d63da233-4123-847a-5d1f32e5b7a4a", "g9b2d63d-a233-4123-847a-5d1f32e5b7a4"} {
+		Expect(isUUID(invalid)).To.Equal(false).Message(invalid)
+	}
+	for _, valid := range []string{"2015-01-01", "1999-12-31"} {
+		Expect(isDate(valid)).To.Equal(true).Message(valid)
+	}
+	for _, invalid := range []string{"", "2015-00-01", "2015-01-32", "2015-1-01", "2015/01/01", "20a5-01-01"} {
+		Expect(isDate(invalid)).To.Equal(false).Message(invalid)
+	}
+	Expect(isAlpha("abcXYZ")).To.Equal(true)
+	Expect(isAlpha("ab1")).To.Equal(false)
+	Expect(isAlpha("a@")).To.Equal(false)
+	Expect(isHex("09afAF")).To.Equal(true)
+	Expect(isHex("0g")).To.Equal(false)
+}
+
+func (_ RouterTests) CatchAll() {
+	router := New(Configure())
+	router.Get("/files/*path", testParamHandler("path"))
+	router.Get("/users/:id/docs/*path", testParamHandler("id", "path"))
+	router.Get("/users/ad*rest", testParamHandler("rest"))
+	router.Get("/*all", testParamHandler("all"))
+
+	assertRouter(router, "GET", "/files/a.txt", "a.txt")
+	assertRouter(router, "GET", "/files/docs/2015/a.txt", "docs/2015/a.txt")
+	assertRouter(router, "GET", "/files/docs/", "docs")
+	assertRouter(router, "GET", "/files", "")
+	assertRouter(router, "GET", "/users/9001/docs/a/b", "9001 a/b")
+	assertRouter(router, "GET", "/users/ADmin/logs/1", "min/logs/1")
+	assertRouter(router, "GET", "/other/a", "other/a")
+}
+
+func (_ RouterTests) CatchAllURL() {
+	router := New(Configure())
+	router.AddNamed("files", "GET", "/files/*path", testHandler("files"))
+	router.AddNamed("admin", "GET", "/users/ad*rest", testHandler("admin"))
+	assertURL(router, "/files/docs/a%20b.txt", "files", "path", "docs/a b.txt")
+	assertURL(router, "/files", "files")
+	assertURL(router, "/users/admin/logs", "admin", "rest", "min/logs")
+}
+
+func (_ RouterTests) Backtracking() {
+	router := New(Configure())
+	router.Get("/a/:x/foo", testParamHandler("x"))
+	router.Get("/a/:y(^\\d+$)/bar", testParamHandler("y"))
+	router.Get("/a/b/c", testHandler("static"))
+	router.Get("/a/:z/d", testParamHandler("z"))
+
+	assertRouter(router, "GET", "/a/1/bar", "1")
+	assertRouter(router, "GET", "/a/1/foo", "1")
+	assertRouter(router, "GET", "/a/b/c", "static")
+	assertRouter(router, "GET", "/a/b/d", "b")
+	assertRouter(router, "GET", "/a/b/foo", "b")
+	assertRouterNotFound(router, "GET", "/a/b/bar")
+}
+
+func (_ RouterTests) StaticRoutesSharingAPrefix() {
+	router := New(Configure())
+	router.Get("/users/list/all", testHandler("all"))
+	router.Get("/users", testHandler("users"))
+	router.Get("/use", testHandler("use"))
+	router.Get("/users/list", testHandler("list"))
+	router.Get("/user/:id", testParamHandler("id"))
+	router.Get("/users/lists", testHandler("lists"))
+	router.Get("/users/list/:id/edit", testParamHandler("id"))
+
+	assertRouter(router, "GET", "/use", "use")
+	assertRouter(router, "GET", "/users", "users")
+	assertRouter(router, "GET", "/users/", "users")
+	assertRouter(router, "GET", "/user/9001", "9001")
+	assertRouter(router, "GET", "/users/list", "list")
+	assertRouter(router, "GET", "/users/lists", "lists")
+	assertRouter(router, "GET", "/users/list/all", "all")
+	assertRouter(router, "GET", "/users/list/4/edit", "4")
+	assertRouterNotFound(router, "GET", "/us")
+	assertRouterNotFound(router, "GET", "/user")
+	assertRouterNotFound(router, "GET", "/users/lis")
+	assertRouterNotFound(router, "GET", "/users/list/al")
+}
+
+func (_ RouterTests) MatchingDoesNotDependOnRegistrationOrder() {
+	routes := []string{
+		"/a/:x/foo",
+		"/a/:y(^\\d+$)/bar",
+		"/a/b/c",
+		"/a/:z:.json",
+		"/a/ad*rest",
+		"/a/*all",
+	}
+	expected := map[string]string{
+		"/a/1/bar":    "/a/:y(^\\d+$)/bar",
+		"/a/1/foo":    "/a/:x/foo",
+		"/a/b/c":      "/a/b/c",
+		"/a/b/foo":    "/a/:x/foo",
+		"/a/b.json":   "/a/:z:.json",
+		"/a/admin":    "/a/ad*rest",
+		"/a/admin/x":  "/a/ad*rest",
+		"/a/1/c":      "/a/*all",
+		"/a/ad.json":  "/a/:z:.json",
+		"/a/ad/foo":   "/a/:x/foo",
+		"/a/ad/b/foo": "/a/ad*rest",
+	}
+	permute(routes, func(routes []string) {
+		router := New(Configure())
+		for _, route := range routes {
+			router.Get(route, testHandler(route))
+		}
+		for path, route := range expected {
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, build.Request().Path(path).Request)
+			Expect(res.Body.String()).To.Equal(route).Message("%s with %v", path, routes)
+		}
+	})
+}
+
+func (_ RouterTests) OverlappingConstraintsMatchInRegistrationOrder() {
+	router := New(Configure())
+	router.Get("/a/:v(hex)", testHandler("hex"))
+	router.Get("/a/:v(int)", testHandler("int"))
+	assertRouter(router, "GET", "/a/9001", "hex")
+	assertRouter(router, "GET", "/a/-1", "int")
+
+	router.Remove("GET", "/a/:v(hex)")
+	router.Get("/a/:v(hex)", testHandler("hex"))
+	assertRouter(router, "GET", "/a/9001", "int")
+}
+
 func (_ RouterTests) RoutingWithConstraint() {
 	router := New(Configure())
 	router.Delete("/admin/*", testHandler("admin-glob"))
@@ -231,6 +1338,278 @@ func (_ RouterTests) RoutingWithConstraint() {
 	assertRouter(router, "DELETE", "/admin/ss", "admin-str")
 }
 
+func (_ RouterTests) ReverseRouting() {
+	router := New(Configure())
+	router.AddNamed("root", "GET", "/", testHandler("root"))
+	router.AddNamed("user", "GET", "/users/:id(^\\d+$)", testHandler("user"))
+	router.AddNamed("user_likes", "GET", "/users/:userId:.json/likes/:id", testHandler("user-likes"))
+	router.AddNamed("admin", "ALL", "/admin/*", testHandler("admin"))
+	router.Get("/users", testHandler("users"))
+
+	assertURL(router, "/", "root")
+	assertURL(router, "/users", "GET:/users")
+	assertURL(router, "/users/9001", "user", "id", "9001")
+	assertURL(router, "/users/goku.json/likes/a%20b", "user_likes", "id", "a b", "userId", "goku")
+	assertURL(router, "/admin", "admin")
+}
+
+func (_ RouterTests) ReverseRoutingErrors() {
+	router := New(Configure())
+	router.AddNamed("user", "GET", "/users/:id(^\\d+$)", testHandler("user"))
+
+	_, err := router.URL("users")
+	Expect(err.Error()).To.Equal(`router: unknown route "users"`)
+	_, err = router.URL("user")
+	Expect(err.Error()).To.Equal(`router: route "user" is missing parameter "id"`)
+	_, err = router.URL("user", "id", "goku")
+	Expect(err.Error()).To.Equal(`router: route "user" parameter "id" value "goku" does not match ^\d+$`)
+}
+
+func (_ RouterTests) Groups() {
+	router := New(Configure())
+	api := router.Group("/api/v1/")
+	api.Get("/", testHandler("api"))
+	api.Get("/users", testHandler("users"))
+	api.Post("users/:id", testHandler("user"))
+	admin := api.Group("admin")
+	admin.Delete("/users/:id", testHandler("admin-user"))
+
+	assertRouter(router, "GET", "/api/v1", "api")
+	assertRouter(router, "GET", "/api/v1/users", "users")
+	assertRouter(router, "POST", "/api/v1/users/9001", "user")
+	assertRouter(router, "DELETE", "/api/v1/admin/users/9001", "admin-user")
+	assertRouterNotFound(router, "GET", "/users")
+	assertURL(router, "/api/v1/users", "GET:/api/v1/users")
+}
+
+func (_ RouterTests) GroupNames() {
+	router := New(Configure())
+	api := router.Group("/api").NamePrefix("api.")
+	api.AddNamed("users", "GET", "/users", testHandler("users"))
+	api.Group("/admin").NamePrefix("admin.").AllNamed("user", "/users/:id", testHandler("admin-user"))
+
+	assertURL(router, "/api/users", "api.users")
+	assertURL(router, "/api/admin/users/9001", "api.admin.user", "id", "9001")
+}
+
+func (_ RouterTests) GroupMiddleware() {
+	router := New(Configure())
+	api := router.Group("/api").Use(testMiddleware("a"), testMiddleware("b"))
+	api.Get("/users", testHandler("users"))
+	api.Group("/admin").Use(testMiddleware("c")).Get("/users", testHandler("admin-users"))
+	api.Get("/other", testHandler("other"))
+	router.Get("/", testHandler("root"))
+
+	assertRouter(router, "GET", "/api/users", "a-b-users")
+	assertRouter(router, "GET", "/api/admin/users", "a-b-c-admin-users")
+	assertRouter(router, "GET", "/api/other", "a-b-other")
+	assertRouter(router, "GET", "/", "root")
+}
+
+func (_ RouterTests) GroupNotFound() {
+	router := New(Configure())
+	api := router.Group("/api").Use(testMiddleware("api"))
+	api.Get("/users", testHandler("users"))
+	api.NotFound(testHandler("api-404"))
+	api.Group("/admin").NotFound(testHandler("admin-404"))
+
+	assertRouter(router, "GET", "/api", "api-api-404")
+	assertRouter(router, "GET", "/api/other", "api-api-404")
+	assertRouter(router, "GET", "/api/admin/other", "api-admin-404")
+	assertRouterNotFound(router, "GET", "/apis")
+	assertRouterNotFound(router, "GET", "/other")
+	assertRouter(router, "GET", "//api/other", "api-api-404")
+	assertRouter(router, "GET", "/./api//admin/other", "api-admin-404")
+}
+
+func (_ RouterTests) Middleware() {
+	router := New(Configure())
+	router.Get("/users", testHandler("users"))
+	router.Use(testMiddleware("a"), testMiddleware("b"))
+	router.Get("/users/:id", testHandler("user"), testMiddleware("c"))
+	router.Group("/admin").Use(testMiddleware("g")).Get("/users", testHandler("admin"), testMiddleware("r"))
+	router.All("/power", testHandler("9000"), testMiddleware("d"))
+
+	assertRouter(router, "GET", "/users", "a-b-users")
+	assertRouter(router, "GET", "/users/9001", "a-b-c-user")
+	assertRouter(router, "GET", "/admin/users", "a-b-g-r-admin")
+	assertRouter(router, "PUT", "/power", "a-b-d-9000")
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Path("/other").Request)
+	Expect(res.Code).To.Equal(200)
+	Expect(res.Body.String()).To.Equal("a-b-")
+}
+
+func (_ RouterTests) Mount() {
+	router := New(Configure())
+	router.Get("/debug/vars", testHandler("vars"))
+	router.Mount("/debug/", http.HandlerFunc(func(out http.ResponseWriter, req *http.Request) {
+		out.Write([]byte(req.Method + " " + req.URL.Path))
+	}))
+	router.Group("/tenants/:tenant").Mount("/files", http.HandlerFunc(func(out http.ResponseWriter, req *http.Request) {
+		r, _ := FromContext(req.Context())
+		out.Write([]byte(r.Param("tenant") + " " + req.URL.Path))
+	}))
+
+	assertRouter(router, "GET", "/debug/vars", "vars")
+	assertRouter(router, "GET", "/debug/pprof/heap", "GET /pprof/heap")
+	assertRouter(router, "POST", "/debug/pprof", "POST /pprof")
+	assertRouter(router, "DELETE", "/debug", "DELETE ")
+	assertRouter(router, "GET", "/tenants/leto/files/a/b.txt", "leto /a/b.txt")
+	assertRouterNotFound(router, "GET", "/debugger")
+
+	assertRouter(router, "GET", "//debug/x", "GET /x")
+	assertRouter(router, "GET", "/./debug/x", "GET /x")
+	assertRouter(router, "GET", "/tenants/leto//files/./a", "leto /a")
+	router.Mount("/raw", http.HandlerFunc(func(out http.ResponseWriter, req *http.Request) {
+		out.Write([]byte(req.URL.EscapedPath()))
+	}))
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/.//raw/a%2Fb", nil))
+	Expect(res.Body.String()).To.Equal("/a%2Fb")
+}
+
+func (_ RouterTests) PathParametersTakePrecedenceOverHostParameters() {
+	router := New(Configure())
+	router.Host(":id.example.com").Get("/users/:id", testParamHandler("id"))
+	router.Host(":id.example.com").Get("/tenant", testParamHandler("id"))
+	assertHostRouter(router, "leto.example.com", "/users/9001", 200, "9001")
+	assertHostRouter(router, "leto.example.com", "/tenant", 200, "leto")
+}
+
+func (_ RouterTests) MountedRequestOutlivesTheHandler() {
+	var kept *Request
+	router := New(Configure())
+	router.Mount("/tenants/:tenant", http.HandlerFunc(func(out http.ResponseWriter, req *http.Request) {
+		kept, _ = FromContext(req.Context())
+	}))
+	router.Get("/users/:id", testParamHandler("id"))
+
+	assertRouter(router, "GET", "/tenants/leto/files", "")
+	assertRouter(router, "GET", "/users/9001", "9001")
+	Expect(kept.Param("tenant")).To.Equal("leto")
+	Expect(kept.URL.Path).To.Equal("/tenants/leto/files")
+}
+
+func (_ RouterTests) HostRouting() {
+	router := New(Configure())
+	router.Get("/users", testHandler("default"))
+	router.Host("admin.example.com").Get("/users", testHandler("admin"))
+	router.Host(":tenant.example.com").Get("/users/:id", func(out http.ResponseWriter, req *Request) {
+		out.Write([]byte(req.Param("tenant") + " " + req.Param("id")))
+	})
+	router.Host(":tenant.example.com").Get("/users", func(out http.ResponseWriter, req *Request) {
+		out.Write([]byte(req.Param("tenant")))
+	})
+
+	assertHostRouter(router, "admin.example.com", "/users", 200, "admin")
+	assertHostRouter(router, "ADMIN.example.com:8080", "/users", 200, "admin")
+	assertHostRouter(router, "leto.example.com", "/users", 200, "leto")
+	assertHostRouter(router, "leto.example.com", "/users/9001", 200, "leto 9001")
+	assertHostRouter(router, "example.com", "/users", 200, "default")
+	assertHostRouter(router, "a.b.example.com", "/users", 200, "default")
+	assertHostRouter(router, "admin.example.com", "/users/9001", 404, "")
+}
+
+func (_ RouterTests) HostRoutingMiddleware() {
+	router := New(Configure())
+	router.Use(testMiddleware("a"))
+	admin := router.Host("admin.example.com")
+	admin.Use(testMiddleware("b"))
+	admin.Get("/users", testHandler("admin"))
+	assertHostRouter(router, "admin.example.com", "/users", 200, "a-b-admin")
+	assertHostRouter(router, "admin.example.com", "/", 200, "a-b-")
+}
+
+func (_ RouterTests) TryAddErrors() {
+	router := New(Configure())
+	Expect(router.TryAdd("GET", "/users/:id(^\\d+$)", testHandler("user"))).To.Equal(nil)
+	Expect(router.TryAddNamed("users", "ALL", "/users", testHandler("users"))).To.Equal(nil)
+
+	assertRouteError(router.TryAdd("GET", "/users/:id(+)", testHandler("")), ErrBadPattern, "router: GET /users/:id(+): bad pattern, invalid constraint for \"id\": error parsing regexp: missing argument to repetition operator: `+`")
+	assertRouteError(router.TryAdd("GET", "/users/:id(^\\d+$", testHandler("")), ErrBadPattern, "router: GET /users/:id(^\\d+$: bad pattern, unterminated constraint for \"id\"")
+	assertRouteError(router.TryAdd("GET", "/users//likes", testHandler("")), ErrBadPattern, "router: GET /users//likes: bad pattern, empty segment")
+	assertRouteError(router.TryAdd("GET", "/users/*/likes", testHandler("")), ErrBadPattern, "router: GET /users/*/likes: bad pattern, only the last segment can contain *")
+	assertRouteError(router.TryAdd("GET", "/users/:", testHandler("")), ErrBadPattern, "router: GET /users/:: bad pattern, missing variable name in \":\"")
+	assertRouteError(router.TryAdd("GET", "/users/:id/likes/:id", testHandler("")), ErrDuplicateVariable, "router: GET /users/:id/likes/:id: duplicate variable, id")
+	assertRouteError(router.TryAdd("GET", "/users/:id(^\\d+$)/", testHandler("")), ErrDuplicateRoute, "router: GET /users/:id(^\\d+$)/: duplicate route")
+	assertRouteError(router.TryAddNamed("users", "GET", "/people", testHandler("")), ErrDuplicateName, "router: GET /people: duplicate name, \"users\" is already used by /users")
+	assertRouteError(router.TryAddNamed("users", "ALL", "/users", testHandler("")), ErrDuplicateRoute, "router: GET /users: duplicate route")
+
+	assertRouter(router, "GET", "/users/9001", "user")
+	assertRouter(router, "PUT", "/users", "users")
+	assertRouter(router, "GET", "/people", "")
+	path, _ := router.URL("users")
+	Expect(path).To.Equal("/users")
+}
+
+func (_ RouterTests) AddRegistersRoutesReusingAName() {
+	router := New(Configure())
+	router.AddNamed("users", "GET", "/users", testHandler("users"))
+	router.AddNamed("users", "GET", "/people", testHandler("people"))
+	assertRouter(router, "GET", "/people", "people")
+	path, _ := router.URL("users")
+	Expect(path).To.Equal("/users")
+}
+
+func (_ RouterTests) RemoveRebuildsURLsFromTheRemainingRoutes() {
+	router := New(Configure())
+	router.AddNamed("n", "GET", "/first", testHandler("first"))
+	router.AddNamed("n", "GET", "/second", testHandler("second"))
+	router.AddNamed("other", "GET", "/other", testHandler("other"))
+	Expect(router.Remove("GET", "/first")).To.Equal(true)
+	path, _ := router.URL("n")
+	Expect(path).To.Equal("/second")
+	path, _ = router.URL("other")
+	Expect(path).To.Equal("/other")
+}
+
+func (_ RouterTests) UnregisteredRoutesDontKeepTheirName() {
+	router := New(Configure())
+	router.Get("/a", testHandler("a"))
+	router.AddNamed("ignored", "GET", "/a", testHandler("ignored"))
+	_, err := router.URL("ignored")
+	Expect(err.Error()).To.Equal(`router: unknown route "ignored"`)
+
+	strict := New(Configure().StrictRoutes())
+	strict.Get("/a", testHandler("a"))
+	func() {
+		defer func() { recover() }()
+		strict.AddNamed("fresh", "GET", "/a", testHandler("fresh"))
+	}()
+	_, err = strict.URL("fresh")
+	Expect(err.Error()).To.Equal(`router: unknown route "fresh"`)
+
+	func() {
+		defer func() { recover() }()
+		strict.Replace(func(b *Builder) {
+			b.AddNamed("replaced", "GET", "/b", testHandler("b"))
+			panic("abort")
+		})
+	}()
+	_, err = strict.URL("replaced")
+	Expect(err.Error()).To.Equal(`router: unknown route "replaced"`)
+	assertRouter(strict, "GET", "/a", "a")
+}
+
+func (_ RouterTests) AddIgnoresDuplicates() {
+	router := New(Configure())
+	router.Get("/users", testHandler("users-1"))
+	router.Get("/users/", testHandler("users-2"))
+	assertRouter(router, "GET", "/users", "users-1")
+}
+
+func (_ RouterTests) AddPanicsOnDuplicatesInStrictMode() {
+	router := New(Configure().StrictRoutes())
+	router.Get("/users", testHandler("users-1"))
+	defer func() {
+		err := recover().(*RouteError)
+		Expect(err.Err).To.Equal(ErrDuplicateRoute)
+	}()
+	router.Get("/users", testHandler("users-2"))
+}
+
 func Benchmark_Router(b *testing.B) {
 	router := New(Configure())
 	router.Get("/users", testHandler("get-users"))
@@ -242,6 +1621,7 @@ func Benchmark_Router(b *testing.B) {
 		build.Request().Path("/users/499/likes/001a").Request,
 		build.Request().Method("Post").Path("/users/943").Request,
 	}
+	b.ReportAllocs()
 	b.ResetTimer()
 	res := httptest.NewRecorder()
 	for i := 0; i < b.N; i++ {
@@ -249,6 +1629,95 @@ func Benchmark_Router(b *testing.B) {
 	}
 }
 
+func Benchmark_ServeStaticRoute(b *testing.B) {
+	benchmarkServe(b, "/users/list", "/users/list")
+}
+
+func Benchmark_ServeParameterRoute(b *testing.B) {
+	benchmarkServe(b, "/users/:userId/likes/:id", "/users/499/likes/001a")
+}
+
+func benchmarkServe(b *testing.B, pattern, path string) {
+	router := New(Configure())
+	router.Get(pattern, noopHandler)
+	req := build.Request().Path(path).Request
+	out := newDiscardWriter()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(out, req)
+	}
+}
+
+// the cost of an Add shouldn't grow with the number of routes
+func Benchmark_AddRoute(b *testing.B) {
+	router := New(Configure())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.AddNamed(strconv.Itoa(i), "GET", "/items/"+strconv.Itoa(i)+"/:id", noopHandler)
+	}
+}
+
+func Benchmark_RegexConstraint(b *testing.B) {
+	benchmarkConstraint(b, "/users/:id(^\\d+$)")
+}
+
+func Benchmark_NamedConstraint(b *testing.B) {
+	benchmarkConstraint(b, "/users/:id(uint)")
+}
+
+func benchmarkConstraint(b *testing.B, pattern string) {
+	router := New(Configure())
+	router.Get(pattern, testHandler("user"))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params, _ := router.LookupByParts("GET", "/users/9001")
+		params.Release()
+	}
+}
+
+func Benchmark_ParametersInOneSegment(b *testing.B) {
+	router := New(Configure())
+	router.Get("/img/:name.:ext", testHandler("img"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params, _ := router.LookupByParts("GET", "/img/leto.tar.gz")
+		params.Release()
+	}
+}
+
+func Benchmark_DeepStaticRoutes(b *testing.B) {
+	router := New(Configure())
+	sections := []string{"accounts", "billing", "catalog", "dashboard", "exports", "features"}
+	for _, a := range sections {
+		for _, c := range sections {
+			router.Get("/api/v1/"+a+"/settings/"+c+"/details", testHandler(a+c))
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params, _ := router.LookupByParts("GET", "/api/v1/features/settings/features/details")
+		params.Release()
+	}
+}
+
+func Benchmark_ManyParameters(b *testing.B) {
+	router := New(Configure())
+	router.Get("/orgs/:org/teams/:team/repos/:repo/issues/:issue/comments/:comment", testHandler("comment"))
+	router.Get("/orgs/:org/teams/:team/repos/:repo/issues/:issue/labels", testHandler("labels"))
+	router.Get("/orgs/:org/teams/:team/repos/:repo/pulls", testHandler("pulls"))
+	router.Get("/orgs/:org/members", testHandler("members"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params, _ := router.LookupByParts("GET", "/orgs/karlseguin/teams/core/repos/router/issues/42/comments/9001")
+		params.Release()
+	}
+}
+
 func assertRouting(routePath, requestPath string, params ...string) {
 	router := New(Configure())
 	router.Get(routePath, func(out http.ResponseWriter, req *Request) {
@@ -271,6 +1740,27 @@ func assertRouter(router *Router, method string, requestPath string, body string
 	Expect(string(res.Body.Bytes())).To.Equal(body)
 }
 
+func assertHostRouter(router *Router, host string, requestPath string, code int, body string) {
+	res := httptest.NewRecorder()
+	req := build.Request().Path(requestPath).Request
+	req.Host = host
+	router.ServeHTTP(res, req)
+	Expect(res.Code).To.Equal(code)
+	Expect(res.Body.String()).To.Equal(body)
+}
+
+func assertRedirect(router *Router, method string, requestPath string, code int, location string) {
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, build.Request().Method(method).URLString(requestPath).Request)
+	Expect(res.Code).To.Equal(code)
+	Expect(res.Header().Get("Location")).To.Equal(location)
+}
+
+func assertRouteError(err error, kind error, message string) {
+	Expect(err.(*RouteError).Err).To.Equal(kind)
+	Expect(err.Error()).To.Equal(message)
+}
+
 func assertNotFound(routePath, method string, requestPath string) {
 	router := New(Configure())
 	router.Get(routePath, func(out http.ResponseWriter, req *Request) {
@@ -287,6 +1777,90 @@ func assertRouterNotFound(router *Router, method string, requestPath string) {
 	Expect(res.Code).To.Equal(404)
 }
 
+func assertURL(router *Router, expected string, name string, params ...string) {
+	url, err := router.URL(name, params...)
+	Expect(err).To.Equal(nil)
+	Expect(url).To.Equal(expected)
+}
+
+func testMiddleware(value string) Middleware {
+	return func(next Handler) Handler {
+		return func(out http.ResponseWriter, req *Request) {
+			out.Write([]byte(value + "-"))
+			next(out, req)
+		}
+	}
+}
+
+// writes the values of the given params, separated by a space
+func testParamHandler(keys ...string) func(out http.ResponseWriter, req *Request) {
+	return func(out http.ResponseWriter, req *Request) {
+		values := make([]string, len(keys))
+		for i, key := range keys {
+			values[i] = req.Param(key)
+		}
+		out.WriteHeader(200)
+		out.Write([]byte(strings.Join(values, " ")))
+	}
+}
+
+// calls f with every permutation of values
+func permute(values []string, f func([]string)) {
+	var generate func(int)
+	generate = func(n int) {
+		if n == 1 {
+			f(values)
+			return
+		}
+		for i := 0; i < n; i++ {
+			generate(n - 1)
+			if n%2 == 0 {
+				values[i], values[n-1] = values[n-1], values[i]
+			} else {
+				values[0], values[n-1] = values[n-1], values[0]
+			}
+		}
+	}
+	generate(len(values))
+}
+
+func noopHandler(out http.ResponseWriter, req *Request) {
+	req.Param("id")
+	out.WriteHeader(200)
+}
+
+type discardWriter struct {
+	header  http.Header
+	headers int
+}
+
+func newDiscardWriter() *discardWriter {
+	return &discardWriter{header: make(http.Header)}
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (w *discardWriter) WriteHeader(status int) {
+	w.headers++
+}
+
+type readerFromWriter struct {
+	*discardWriter
+	read int64
+}
+
+func (w *readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	n, err := io.Copy(io.Discard, src)
+	w.read += n
+	return n, err
+}
+
 func testHandler(body string) func(out http.ResponseWriter, req *Request) {
 	return func(out http.ResponseWriter, req *Request) {
 		out.WriteHeader(200)
@@ -0,0 +1,209 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// the most parameters a single segment can have
+const maxSegmentParams = 8
+
+// A piece of a segment, either literal text or a parameter
+type token struct {
+	literal    string
+	variable   string
+	constraint *constraint
+}
+
+// Parses a segment such as :from-:to or :id(int):.json. A ':' directly after
+// a parameter, and not followed by a name, is dropped so that the original
+// :id:.json postfix syntax still works.
+func (r *Router) parseSegment(part string) ([]token, error) {
+	if tokens, ok, err := r.parseParam(part); ok {
+		return tokens, err
+	}
+	var tokens []token
+	params := 0
+	for i := 0; i < len(part); {
+		if part[i] != ':' {
+			j := strings.IndexByte(part[i+1:], ':')
+			if j == -1 {
+				j = len(part)
+			} else {
+				j += i + 1
+			}
+			tokens = appendLiteral(tokens, part[i:j])
+			i = j
+			continue
+		}
+
+		if i+1 == len(part) || isNameByte(part[i+1]) == false {
+			if i == 0 {
+				return nil, fmt.Errorf("missing variable name in %q", part)
+			}
+			if last := tokens[len(tokens)-1]; len(last.variable) == 0 {
+				tokens = appendLiteral(tokens, ":")
+			}
+			i++
+			continue
+		}
+
+		j := i + 1
+		for j < len(part) && isNameByte(part[j]) {
+			j++
+		}
+		t := token{variable: part[i+1 : j]}
+		if j < len(part) && part[j] == '(' {
+			end := closingParen(part, j)
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated constraint for %q", t.variable)
+			}
+			c, err := r.newConstraint(part[j+1 : end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid constraint for %q: %s", t.variable, err)
+			}
+			t.constraint, j = c, end+1
+		}
+		if l := len(tokens); l > 0 && len(tokens[l-1].variable) > 0 {
+			return nil, fmt.Errorf("parameters %q and %q must be separated by text", tokens[l-1].variable, t.variable)
+		}
+		if params++; params > maxSegmentParams {
+			return nil, errors.New("too many parameters in one segment")
+		}
+		tokens = append(tokens, t)
+		i = j
+	}
+	return tokens, nil
+}
+
+// A segment with a single parameter, optionally followed by a postfix, is
+// parsed as it always was: the name runs up to the constraint or postfix, so
+// that names such as :user-id keep working. ok is false for segments which
+// need parseSegment's syntax.
+func (r *Router) parseParam(part string) ([]token, bool, error) {
+	if part[0] != ':' {
+		return nil, false, nil
+	}
+	n := len(part)
+	if i := strings.IndexAny(part[1:], "(:"); i != -1 {
+		n = i + 1
+	}
+	rest, source := part[n:], ""
+	if len(rest) != 0 && rest[0] == '(' {
+		end := closingParen(rest, 0)
+		if end == -1 {
+			return nil, false, nil
+		}
+		source, rest = rest[1:end], rest[end+1:]
+	}
+	if len(rest) != 0 && (rest[0] != ':' || (len(rest) > 1 && isNameByte(rest[1]))) {
+		return nil, false, nil
+	}
+
+	t := token{variable: part[1:n]}
+	if len(t.variable) == 0 {
+		return nil, true, fmt.Errorf("missing variable name in %q", part)
+	}
+	if strings.IndexByte(t.variable, ')') != -1 {
+		return nil, true, fmt.Errorf("invalid variable name %q", t.variable)
+	}
+	if n < len(part) && part[n] == '(' {
+		c, err := r.newConstraint(source)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid constraint for %q: %s", t.variable, err)
+		}
+		t.constraint = c
+	}
+	tokens := []token{t}
+	if len(rest) > 1 {
+		tokens = append(tokens, token{literal: rest[1:]})
+	}
+	return tokens, true, nil
+}
+
+func tokenVariables(tokens []token) []string {
+	var variables []string
+	for _, t := range tokens {
+		if len(t.variable) != 0 {
+			variables = append(variables, t.variable)
+		}
+	}
+	return variables
+}
+
+func appendLiteral(tokens []token, literal string) []token {
+	if l := len(tokens); l > 0 && len(tokens[l-1].variable) == 0 {
+		tokens[l-1].literal += literal
+		return tokens
+	}
+	return append(tokens, token{literal: literal})
+}
+
+// the index of the ')' closing the '(' at start, or -1
+func closingParen(part string, start int) int {
+	depth := 0
+	for i := start; i < len(part); i++ {
+		switch part[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func isNameByte(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
+
+// Matches value against tokens, writing each parameter's value to captured.
+// When a parameter is followed by text which appears more than once, the
+// longest value is tried first, so :name.:ext captures "a.tar" and "gz"
+// from "a.tar.gz".
+func matchTokens(tokens []token, value string, captured []string) bool {
+	if len(tokens) == 0 {
+		return len(value) == 0
+	}
+	t := tokens[0]
+	if len(t.variable) == 0 {
+		return strings.HasPrefix(value, t.literal) && matchTokens(tokens[1:], value[len(t.literal):], captured)
+	}
+	if len(tokens) == 1 {
+		if len(value) == 0 || (t.constraint != nil && t.constraint.match(value) == false) {
+			return false
+		}
+		captured[0] = value
+		return true
+	}
+	next := tokens[1].literal
+	for end := strings.LastIndex(value, next); end > 0; end = strings.LastIndex(value[:end], next) {
+		v := value[:end]
+		if (t.constraint == nil || t.constraint.match(v)) && matchTokens(tokens[2:], value[end+len(next):], captured[1:]) {
+			captured[0] = v
+			return true
+		}
+	}
+	return false
+}
+
+// identifies segments which match the same values, regardless of the
+// names given to their parameters
+func tokensKey(tokens []token) string {
+	key := ""
+	for _, t := range tokens {
+		if len(t.variable) == 0 {
+			key += t.literal
+		} else if t.constraint == nil {
+			key += "\x00"
+		} else {
+			key += "\x00" + t.constraint.source + "\x00"
+		}
+	}
+	return key
+}
@@ -0,0 +1,344 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// The routes of a router. Tables are never changed once published, changes
+// are made to a copy which then replaces the router's table, so requests
+// always see a consistent set of routes without locking.
+type table struct {
+	routes map[string]*RoutePart
+	// names are only ever added, until a route is removed, so the url
+	// templates are shared with the table's copies rather than copied. Names
+	// registered on a copy are kept in added until the copy is published.
+	urls          *sync.Map
+	added         map[string]*urlTemplate
+	notFounds     []prefixedAction
+	registrations []registration
+}
+
+// A route as it was registered, used to rebuild the tree when routes are
+// removed. path is one of the variants of the pattern.
+type registration struct {
+	method  string
+	pattern string
+	path    string
+	action  *Action
+}
+
+func newTable() *table {
+	return &table{
+		routes: make(map[string]*RoutePart),
+		urls:   new(sync.Map),
+	}
+}
+
+// RoutePart trees are copied as they're changed, so the roots can be shared.
+// Registrations are only appended to, past the end of what t sees. A copy
+// thus costs the same regardless of the number of routes.
+func (t *table) clone() *table {
+	c := &table{
+		routes:        make(map[string]*RoutePart, len(t.routes)),
+		urls:          t.urls,
+		notFounds:     t.notFounds,
+		registrations: t.registrations,
+	}
+	for method, rp := range t.routes {
+		c.routes[method] = rp
+	}
+	return c
+}
+
+func (t *table) url(name string) (*urlTemplate, bool) {
+	if template, exists := t.added[name]; exists {
+		return template, true
+	}
+	template, exists := t.urls.Load(name)
+	if exists == false {
+		return nil, false
+	}
+	return template.(*urlTemplate), true
+}
+
+func (r *Router) table() *table {
+	return r.tables.Load().(*table)
+}
+
+// Applies change to a copy of the current table and then publishes it.
+// Writers are serialized, readers are never blocked.
+func (r *Router) update(change func(b *Builder) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	b := &Builder{router: r, table: r.table().clone()}
+	err := change(b)
+	b.table.publish()
+	r.tables.Store(b.table)
+	return err
+}
+
+// Shares the names registered on t. Done just before t replaces the
+// router's table, so a change which panics leaves the names untouched.
+func (t *table) publish() {
+	for name, template := range t.added {
+		t.urls.Store(name, template)
+	}
+	t.added = nil
+}
+
+// The handlers, middleware, hosts and named constraints of a router. Like
+// tables, states are copied as they're changed.
+type state struct {
+	notFound         *Action
+	methodNotAllowed *Action
+	panicHandler     func(out http.ResponseWriter, req *Request, recovered interface{})
+	handler          Handler
+	middleware       []Middleware
+	hosts            []*host
+	constraints      map[string]func(string) bool
+}
+
+func (r *Router) state() *state {
+	return r.states.Load().(*state)
+}
+
+// Applies change to a copy of the current state and then publishes it
+func (r *Router) change(change func(s *state)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s := *r.state()
+	change(&s)
+	r.states.Store(&s)
+}
+
+// Removes the route registered for method and path, where path is the
+// pattern given when the route was added. As with Add, "ALL" removes the
+// route for every method. Returns false if no such route exists.
+func (r *Router) Remove(method, path string) bool {
+	removed := false
+	r.update(func(b *Builder) error {
+		removed = b.Remove(method, path)
+		return nil
+	})
+	return removed
+}
+
+// Replaces every route, including group not found handlers, with those
+// registered by build. Requests keep being served by the existing routes
+// until build returns. If build panics, the existing routes are kept. build
+// must register routes through the Builder and not through the Router.
+func (r *Router) Replace(build func(*Builder)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	b := &Builder{router: r, table: newTable()}
+	build(b)
+	b.table.publish()
+	r.tables.Store(b.table)
+}
+
+// Registers routes on a table which isn't yet visible to requests
+type Builder struct {
+	router *Router
+	table  *table
+}
+
+func (b *Builder) Group(prefix string) *Group {
+	return &Group{router: b, prefix: cleanPrefix(prefix)}
+}
+
+func (b *Builder) Mount(prefix string, handler http.Handler, middleware ...Middleware) {
+	prefix = cleanPrefix(prefix)
+	b.All(prefix+"/*", mount(prefix, handler), middleware...)
+}
+
+func (b *Builder) Add(method, path string, handler Handler, middleware ...Middleware) {
+	b.AddNamed(method+":"+path, method, path, handler, middleware...)
+}
+
+func (b *Builder) AddNamed(name, method, path string, handler Handler, middleware ...Middleware) {
+	b.Define(Definition{Name: name, Method: method, Path: path, Handler: handler, Middleware: middleware})
+}
+
+func (b *Builder) TryAdd(method, path string, handler Handler, middleware ...Middleware) error {
+	return b.TryAddNamed(method+":"+path, method, path, handler, middleware...)
+}
+
+func (b *Builder) TryAddNamed(name, method, path string, handler Handler, middleware ...Middleware) error {
+	return b.TryDefine(Definition{Name: name, Method: method, Path: path, Handler: handler, Middleware: middleware})
+}
+
+func (b *Builder) Define(def Definition) {
+	if err := b.TryDefine(def); err != nil {
+		if b.router.config.strictRoutes || isDuplicate(err) == false {
+			panic(err)
+		}
+	}
+}
+
+func (b *Builder) TryDefine(def Definition) error {
+	r, t := b.router, b.table
+	name, method, path := def.Name, def.Method, def.Path
+	if len(name) == 0 {
+		name = method + ":" + path
+	}
+	variants, err := r.expandOptional(path)
+	if err != nil {
+		return &RouteError{Method: method, Path: path, Err: ErrBadPattern, Reason: err.Error()}
+	}
+	// the longest variant has every variable
+	variables, err := r.validatePattern(variants[len(variants)-1].path)
+	if err != nil {
+		return &RouteError{Method: method, Path: path, Err: ErrBadPattern, Reason: err.Error()}
+	}
+	if v := duplicate(variables); len(v) != 0 {
+		return &RouteError{Method: method, Path: path, Err: ErrDuplicateVariable, Reason: v}
+	}
+
+	methods := []string{method}
+	if method == "ALL" {
+		methods = AllMethods
+	}
+	handler := chain(def.Handler, def.Middleware)
+	registered := false
+	var meta map[string]interface{}
+	if len(def.Meta) != 0 {
+		meta = make(map[string]interface{}, len(def.Meta))
+		for key, value := range def.Meta {
+			meta[key] = value
+		}
+	}
+	for _, m := range methods {
+		rp, exists := t.routes[m]
+		if exists == false {
+			rp = newRoutePart()
+		}
+		route := newRoute(name, m, path, meta)
+		for _, v := range variants {
+			action := newAction(name, v.path, handler)
+			action.defaults, action.doc, action.route = v.defaults, def.Doc, route
+			root, ok := r.add(rp, v.path, action)
+			if ok == false {
+				if err == nil {
+					err = &RouteError{Method: m, Path: v.path, Err: ErrDuplicateRoute}
+				}
+				continue
+			}
+			t.routes[m], rp, registered = root, root, true
+			t.registrations = append(t.registrations, registration{method: m, pattern: path, path: v.path, action: action})
+		}
+	}
+	// the route is registered either way, but the name keeps its first path
+	if existing, exists := t.url(name); exists == false {
+		if registered {
+			if t.added == nil {
+				t.added = make(map[string]*urlTemplate)
+			}
+			t.added[name] = r.newURLTemplate(path)
+		}
+	} else if existing.pattern != path && err == nil {
+		err = &RouteError{Method: method, Path: path, Err: ErrDuplicateName, Reason: fmt.Sprintf("%q is already used by %s", name, existing.pattern)}
+	}
+	return err
+}
+
+func (b *Builder) All(path string, handler Handler, middleware ...Middleware) {
+	for _, method := range AllMethods {
+		b.Add(method, path, handler, middleware...)
+	}
+}
+
+func (b *Builder) AllNamed(name, path string, handler Handler, middleware ...Middleware) {
+	for _, method := range AllMethods {
+		b.AddNamed(name, method, path, handler, middleware...)
+	}
+}
+
+func (b *Builder) Get(path string, handler Handler, middleware ...Middleware) {
+	b.Add("GET", path, handler, middleware...)
+}
+
+func (b *Builder) Post(path string, handler Handler, middleware ...Middleware) {
+	b.Add("POST", path, handler, middleware...)
+}
+
+func (b *Builder) Put(path string, handler Handler, middleware ...Middleware) {
+	b.Add("PUT", path, handler, middleware...)
+}
+
+func (b *Builder) Delete(path string, handler Handler, middleware ...Middleware) {
+	b.Add("DELETE", path, handler, middleware...)
+}
+
+func (b *Builder) Purge(path string, handler Handler, middleware ...Middleware) {
+	b.Add("PURGE", path, handler, middleware...)
+}
+
+func (b *Builder) Patch(path string, handler Handler, middleware ...Middleware) {
+	b.Add("PATCH", path, handler, middleware...)
+}
+
+func (b *Builder) Options(path string, handler Handler, middleware ...Middleware) {
+	b.Add("OPTIONS", path, handler, middleware...)
+}
+
+func (b *Builder) Head(path string, handler Handler, middleware ...Middleware) {
+	b.Add("HEAD", path, handler, middleware...)
+}
+
+// Removing a route rebuilds the trees, and the url templates, from the
+// remaining registrations. A name's template is that of its first remaining
+// registration.
+func (b *Builder) Remove(method, path string) bool {
+	t := b.table
+	kept := make([]registration, 0, len(t.registrations))
+	for _, reg := range t.registrations {
+		if reg.pattern == path && (method == "ALL" || method == reg.method) {
+			continue
+		}
+		kept = append(kept, reg)
+	}
+	if len(kept) == len(t.registrations) {
+		return false
+	}
+	routes := make(map[string]*RoutePart, len(t.routes))
+	added := make(map[string]*urlTemplate)
+	for _, reg := range kept {
+		rp, exists := routes[reg.method]
+		if exists == false {
+			rp = newRoutePart()
+		}
+		routes[reg.method], _ = b.router.add(rp, reg.path, reg.action)
+		name := reg.action.Name
+		if _, exists := added[name]; exists {
+			continue
+		}
+		if template, exists := t.url(name); exists && template.pattern == reg.pattern {
+			added[name] = template
+		} else {
+			added[name] = b.router.newURLTemplate(reg.pattern)
+		}
+	}
+	// the live table shares urls, so the templates get a map of their own
+	t.routes, t.urls, t.added, t.registrations = routes, new(sync.Map), added, kept
+	return true
+}
+
+// not found handlers registered by groups, longest prefix first
+func (b *Builder) addNotFound(prefix string, handler Handler) {
+	t := b.table
+	notFound := prefixedAction{prefix, &Action{Handler: handler}}
+	notFounds := make([]prefixedAction, 0, len(t.notFounds)+1)
+	for _, existing := range t.notFounds {
+		if existing.prefix != prefix {
+			notFounds = append(notFounds, existing)
+		}
+	}
+	notFounds = append(notFounds, notFound)
+	sort.SliceStable(notFounds, func(i, j int) bool {
+		return len(notFounds[i].prefix) > len(notFounds[j].prefix)
+	})
+	t.notFounds = notFounds
+}
@@ -0,0 +1,122 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// A piece of a route's path. Literal pieces have an empty variable.
+type urlPart struct {
+	literal    string
+	variable   string
+	constraint *constraint
+	catchAll   bool
+	slash      bool
+	optional   bool
+}
+
+type urlTemplate struct {
+	pattern string
+	parts   []urlPart
+}
+
+// path must be a valid pattern
+func (r *Router) newURLTemplate(path string) *urlTemplate {
+	template := &urlTemplate{pattern: path}
+	path = strings.Trim(path, "/")
+	if len(path) == 0 {
+		return template
+	}
+	var urlParts []urlPart
+	for _, part := range strings.Split(path, "/") {
+		if prefix, variable, ok := splitCatchAll(part); ok {
+			if len(prefix) > 0 || len(variable) > 0 {
+				urlParts = append(urlParts, urlPart{literal: prefix, slash: true})
+			}
+			if len(variable) > 0 {
+				urlParts = append(urlParts, urlPart{variable: variable, catchAll: true})
+			}
+			break
+		}
+		if strings.IndexByte(part, ':') == -1 {
+			urlParts = append(urlParts, urlPart{literal: part, slash: true})
+			continue
+		}
+		segment, _, optional, _ := splitOptional(part)
+		tokens, _ := r.parseSegment(segment)
+		for i, t := range tokens {
+			urlParts = append(urlParts, urlPart{literal: t.literal, variable: t.variable, constraint: t.constraint, slash: i == 0, optional: optional})
+		}
+	}
+	template.parts = urlParts
+	return template
+}
+
+// Builds the path of the route registered under name. params are key/value
+// pairs used to fill in the route's variables, such as "id", "9001". Optional
+// segments are omitted from the first one without a value.
+func (r *Router) URL(name string, params ...string) (string, error) {
+	template, exists := r.table().url(name)
+	if exists == false {
+		return "", fmt.Errorf("router: unknown route %q", name)
+	}
+	parts := template.parts
+	if len(parts) == 0 {
+		return "/", nil
+	}
+	buffer := make([]byte, 0, 64)
+	segment := 0
+	for _, part := range parts {
+		if part.slash {
+			segment = len(buffer)
+			buffer = append(buffer, '/')
+		}
+		if len(part.variable) == 0 {
+			buffer = append(buffer, part.literal...)
+			continue
+		}
+		value, ok := lookupParam(params, part.variable)
+		if ok == false && part.optional {
+			// the whole segment, including its text, is omitted
+			buffer = buffer[:segment]
+			break
+		}
+		if part.catchAll {
+			buffer = append(buffer, escapeSegments(value)...)
+			continue
+		}
+		if ok == false {
+			return "", fmt.Errorf("router: route %q is missing parameter %q", name, part.variable)
+		}
+		if part.constraint != nil && part.constraint.match(value) == false {
+			return "", fmt.Errorf("router: route %q parameter %q value %q does not match %s", name, part.variable, value, part.constraint)
+		}
+		buffer = append(buffer, url.PathEscape(value)...)
+	}
+	if l := len(buffer); l > 1 && buffer[l-1] == '/' {
+		buffer = buffer[:l-1]
+	}
+	if len(buffer) == 0 {
+		return "/", nil
+	}
+	return string(buffer), nil
+}
+
+// escapes each segment of a catch-all value, leaving the slashes as-is
+func escapeSegments(value string) string {
+	segments := strings.Split(value, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func lookupParam(params []string, key string) (string, bool) {
+	for i := 0; i < len(params)-1; i += 2 {
+		if params[i] == key {
+			return params[i+1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,90 @@
+package router
+
+import (
+	"strings"
+)
+
+// A registered route, as given to Walk
+type RouteInfo struct {
+	Method string
+	// the path as it was registered, such as /users/:id(uint)/likes
+	Pattern string
+	Name    string
+	Params  []ParamInfo
+	// set when the route ends with a prefix, such as /users/ad*, or a glob,
+	// such as /users/*
+	Prefix bool
+	Glob   bool
+}
+
+type ParamInfo struct {
+	Name string
+	// the named constraint or regular expression, empty if there's none
+	Constraint string
+	// the text following the parameter within its segment, such as the .json
+	// of :id:.json
+	Postfix  string
+	Optional bool
+	Default  string
+	// set for the parameter capturing the rest of a prefix or glob route
+	CatchAll bool
+}
+
+// Calls fn for every route, in the order they were registered, until fn
+// returns an error, which is then returned. Routes added or removed while
+// walking aren't seen.
+func (r *Router) Walk(fn func(RouteInfo) error) error {
+	var previous registration
+	for _, reg := range r.table().registrations {
+		// the variants of an optional pattern are registered one after the other
+		if reg.method == previous.method && reg.pattern == previous.pattern {
+			continue
+		}
+		previous = reg
+		info := RouteInfo{Method: reg.method, Pattern: reg.pattern, Name: reg.action.Name}
+		r.describe(&info)
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fills in the parameters and the prefix and glob flags from the pattern,
+// which is known to be valid
+func (r *Router) describe(info *RouteInfo) {
+	path := strings.Trim(info.Pattern, "/")
+	if len(path) == 0 {
+		return
+	}
+	for _, part := range strings.Split(path, "/") {
+		if prefix, variable, ok := splitCatchAll(part); ok {
+			info.Prefix, info.Glob = len(prefix) != 0, len(prefix) == 0
+			if len(variable) != 0 {
+				info.Params = append(info.Params, ParamInfo{Name: variable, CatchAll: true})
+			}
+			return
+		}
+		if strings.IndexByte(part, ':') == -1 {
+			continue
+		}
+		segment, value, optional, hasDefault := splitOptional(part)
+		tokens, _ := r.parseSegment(segment)
+		for i, t := range tokens {
+			if len(t.variable) == 0 {
+				continue
+			}
+			param := ParamInfo{Name: t.variable, Optional: optional}
+			if hasDefault {
+				param.Default = value
+			}
+			if t.constraint != nil {
+				param.Constraint = t.constraint.source
+			}
+			if i+1 < len(tokens) {
+				param.Postfix = tokens[i+1].literal
+			}
+			info.Params = append(info.Params, param)
+		}
+	}
+}